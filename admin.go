@@ -0,0 +1,267 @@
+// Copyright 2023 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/xgfone/emailmanager/pkg/controller"
+	"github.com/xgfone/emailmanager/pkg/email"
+	"github.com/xgfone/emailmanager/pkg/metrics"
+)
+
+// adminConfig is the config of the admin http server.
+type adminConfig struct {
+	Addr   string // If empty, disable the admin http server.
+	Token  string // If empty, disable the auth, only allowed on the loopback address.
+	Health healthConfig
+}
+
+// check checks whether the admin http server is allowed to start,
+// which must be protected by the token unless it listens on
+// the loopback address.
+func (c adminConfig) check() error {
+	if c.Addr == "" || c.Token != "" {
+		return nil
+	}
+
+	host, _, err := net.SplitHostPort(c.Addr)
+	if err != nil {
+		return fmt.Errorf("invalid admin address '%s': %w", c.Addr, err)
+	}
+	if !isLoopback(host) {
+		return fmt.Errorf("the admin token must be set when listening on the non-loopback address '%s'", c.Addr)
+	}
+	return nil
+}
+
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// serveAdmin runs the admin http server until ctx is done.
+//
+// The apis are as follow, which return the json response:
+//
+//	GET  /controllers                   List all the controllers and their last-run status.
+//	GET  /controllers/{name}            Get the last-run status of the controller.
+//	GET  /controllers/{name}/history    Get the results of the recent checks of the controller.
+//	POST /controllers/{name}/check      Trigger the controller to check the emails immediately.
+//	POST /controllers/{name}/pause      Pause the scheduled checks of the controller.
+//	POST /controllers/{name}/resume     Resume the scheduled checks of the controller.
+//	GET  /controllers/{name}/mailboxes  List the mailboxes of the controller, filtered by ?pattern=*.
+//	POST /reload                        Reload the controller configs.
+//	GET  /metrics                       Expose the metrics in the Prometheus text format.
+//	GET  /healthz                       Report that the program is alive.
+//	GET  /readyz                        Report whether all the controllers are ready.
+//
+// The name of the controller may contain "/". The triggered check is
+// refused with 409 if the last triggered one has not finished.
+//
+// The config must have been checked by adminConfig.check.
+//
+// If the token is set, the request must carry it by the header
+// "Authorization: Bearer <token>", except /healthz and /readyz,
+// which are used by the probes of the container orchestrator.
+func serveAdmin(ctx context.Context, m *manager, config adminConfig) {
+	if config.Token == "" {
+		slog.Warn("the admin api is not protected by the token", "addr", config.Addr)
+	}
+
+	a := admin{manager: m, health: config.Health}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/controllers", a.listControllers)
+	mux.HandleFunc("/controllers/", a.handleController)
+	mux.HandleFunc("/reload", a.reload)
+	mux.Handle("/metrics", metrics.Handler())
+
+	root := http.NewServeMux()
+	root.HandleFunc("/healthz", healthz)
+	root.HandleFunc("/readyz", a.readyz)
+	root.Handle("/", authToken(config.Token, mux))
+
+	server := &http.Server{
+		Addr:              config.Addr,
+		Handler:           root,
+		ReadHeaderTimeout: time.Second * 10,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+		server.Shutdown(shutdownctx)
+	}()
+
+	slog.Info("start the admin http server", "addr", config.Addr)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("fail to run the admin http server", "addr", config.Addr, "err", err)
+	}
+}
+
+func authToken(token string, next http.Handler) http.Handler {
+	if token == "" {
+		return next
+	}
+
+	expected := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			writeError(w, http.StatusUnauthorized, "invalid token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("fail to write the json response", "err", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, code int, err string) {
+	writeJSON(w, code, map[string]string{"error": err})
+}
+
+func checkMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return false
+	}
+	return true
+}
+
+type admin struct {
+	manager *manager
+	health  healthConfig
+}
+
+func (a admin) listControllers(w http.ResponseWriter, r *http.Request) {
+	if !checkMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	controllers := a.manager.Controllers()
+	statuses := make([]controller.Status, len(controllers))
+	for i, c := range controllers {
+		statuses[i] = c.Status()
+	}
+	writeJSON(w, http.StatusOK, statuses)
+}
+
+func (a admin) reload(w http.ResponseWriter, r *http.Request) {
+	if !checkMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	if err := a.manager.sync(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "reloaded"})
+}
+
+// controllerActions are the actions of the controller api,
+// which are the last segment of the path.
+var controllerActions = []string{"history", "check", "pause", "resume", "mailboxes"}
+
+func (a admin) handleController(w http.ResponseWriter, r *http.Request) {
+	// The name of the controller may contain "/",
+	// so match the action only if the rest is a controller.
+	name, action := strings.TrimPrefix(r.URL.Path, "/controllers/"), ""
+	for _, _action := range controllerActions {
+		if prefix, ok := strings.CutSuffix(name, "/"+_action); ok {
+			if _, _, exist := a.manager.Controller(prefix); exist {
+				name, action = prefix, _action
+				break
+			}
+		}
+	}
+
+	c, _, ok := a.manager.Controller(name)
+	if !ok {
+		writeError(w, http.StatusNotFound, "no controller named '"+name+"'")
+		return
+	}
+
+	switch action {
+	case "":
+		if checkMethod(w, r, http.MethodGet) {
+			writeJSON(w, http.StatusOK, c.Status())
+		}
+
+	case "history":
+		if checkMethod(w, r, http.MethodGet) {
+			writeJSON(w, http.StatusOK, c.History())
+		}
+
+	case "check":
+		if checkMethod(w, r, http.MethodPost) {
+			// The check may take a long time, so do it in the background.
+			if !c.TriggerCheck(a.manager.Context()) {
+				writeError(w, http.StatusConflict, "the triggered check is in progress")
+				return
+			}
+			writeJSON(w, http.StatusAccepted, map[string]string{"status": "checking"})
+		}
+
+	case "pause":
+		if checkMethod(w, r, http.MethodPost) {
+			c.Pause()
+			slog.Info("pause the controller", "controller", name)
+			writeJSON(w, http.StatusOK, c.Status())
+		}
+
+	case "resume":
+		if checkMethod(w, r, http.MethodPost) {
+			c.Resume()
+			slog.Info("resume the controller", "controller", name)
+			writeJSON(w, http.StatusOK, c.Status())
+		}
+
+	case "mailboxes":
+		if checkMethod(w, r, http.MethodGet) {
+			ctx, cancel := context.WithTimeout(r.Context(), time.Second*30)
+			defer cancel()
+
+			mailboxes, err := c.GetMailBoxes(ctx, r.URL.Query().Get("pattern"))
+			if err != nil {
+				writeError(w, http.StatusBadGateway, err.Error())
+				return
+			}
+			if mailboxes == nil {
+				mailboxes = []email.Mailbox{}
+			}
+			writeJSON(w, http.StatusOK, mailboxes)
+		}
+	}
+}
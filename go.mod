@@ -9,6 +9,7 @@ require (
 	github.com/xgfone/go-defaults v0.13.0
 	github.com/xgfone/go-structs v0.2.0
 	github.com/xgfone/goapp v0.58.0
+	go.etcd.io/bbolt v1.3.8
 )
 
 require (
@@ -16,6 +17,7 @@ require (
 	github.com/emersion/go-textwrapper v0.0.0-20200911093747-65d896831594 // indirect
 	github.com/xgfone/go-cast v0.8.1 // indirect
 	github.com/xgfone/gover v0.5.0 // indirect
+	golang.org/x/sys v0.13.0 // indirect
 	golang.org/x/text v0.12.0 // indirect
 )
 
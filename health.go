@@ -0,0 +1,95 @@
+// Copyright 2023 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/xgfone/emailmanager/pkg/controller"
+)
+
+// healthConfig is the config to check the readiness of the controllers.
+type healthConfig struct {
+	// A controller is not ready if it has failed to log in to the mail server
+	// for AuthFailures consecutive times. If 0, disable the check.
+	AuthFailures int
+
+	// A controller is not ready if it has not checked the emails successfully
+	// for StaleMultiple times of its interval. If 0, disable the check.
+	StaleMultiple float64
+}
+
+type controllerHealth struct {
+	Ready  bool   `json:"ready"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// checkReady checks whether the controller is ready by its status.
+func checkReady(status controller.Status, config healthConfig, now time.Time) (health controllerHealth) {
+	if config.AuthFailures > 0 && status.AuthFailures >= config.AuthFailures {
+		health.Reason = fmt.Sprintf("failed to log in to the mail server %d times consecutively",
+			status.AuthFailures)
+		return
+	}
+
+	if config.StaleMultiple > 0 && !status.Paused && status.Interval > 0 {
+		last, what := status.LastSuccess, "last success"
+		if last.IsZero() {
+			last, what = status.Started, "start"
+		}
+
+		maxAge := time.Duration(float64(status.Interval) * config.StaleMultiple)
+		if age := now.Sub(last); age > maxAge {
+			health.Reason = fmt.Sprintf("no successful check for %s since %s, exceeding %s",
+				age.Truncate(time.Second), what, maxAge)
+			if status.Last != nil && len(status.Last.Errors) > 0 {
+				health.Reason += ": " + strings.Join(status.Last.Errors, "; ")
+			}
+			return
+		}
+	}
+
+	health.Ready = true
+	return
+}
+
+// healthz reports that the program is alive.
+func healthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// readyz reports whether all the controllers are ready, with the reason
+// of each controller which is not ready.
+func (a admin) readyz(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	ready := true
+	controllers := a.manager.Controllers()
+	healths := make(map[string]controllerHealth, len(controllers))
+	for _, c := range controllers {
+		status := c.Status()
+		health := checkReady(status, a.health, now)
+		healths[status.Name] = health
+		ready = ready && health.Ready
+	}
+
+	code, status := http.StatusOK, "ready"
+	if !ready {
+		code, status = http.StatusServiceUnavailable, "unready"
+	}
+	writeJSON(w, code, map[string]interface{}{"status": status, "controllers": healths})
+}
@@ -15,17 +15,71 @@
 package main
 
 import (
+	"log/slog"
+
 	"github.com/xgfone/emailmanager/pkg/config"
+	"github.com/xgfone/emailmanager/pkg/email"
 	"github.com/xgfone/gconf/v6"
+	"github.com/xgfone/go-defaults"
 	"github.com/xgfone/goapp"
 )
 
 var (
-	storageGroup    = gconf.Group("storage")
-	filestoragepath = storageGroup.NewString("file.path", "", "The path of the json file storing the configs.")
+	storageGroup     = gconf.Group("storage")
+	filestoragepath  = storageGroup.NewString("file.path", "", "The path of the json file storing the configs.")
+	filestoragewatch = storageGroup.NewBool("file.watch", true, "Whether to reload the configs automatically when the json file changes.")
+	statestoragepath = storageGroup.NewString("state.path", "", "The path of the json file storing the fetch states of the mailboxes. If empty, store them in memory.")
+	outboxpath       = storageGroup.NewString("outbox.path", "", "The path of the json file storing the pending notices to be redelivered. If empty, store them in memory.")
+
+	adminGroup = gconf.Group("admin")
+	adminaddr  = adminGroup.NewString("addr", "", "The address of the admin http server, such as 127.0.0.1:8080. If empty, disable it.")
+	admintoken = adminGroup.NewString("token", "", "The token to access the admin api by the header \"Authorization: Bearer <token>\". If empty, disable the auth, which is only allowed when addr is a loopback address.")
+
+	healthGroup         = adminGroup.Group("health")
+	healthauthfailures  = healthGroup.NewInt("authfailures", 3, "The controller is not ready after failing to log in for the consecutive times. If 0, disable the check.")
+	healthstalemultiple = healthGroup.NewFloat64("stalemultiple", 3, "The controller is not ready if no successful check for the multiple of its interval. If 0, disable the check.")
 )
 
 func main() {
 	goapp.Init()
-	run(config.FileLoader(filestoragepath.Get()))
+	var watchfile string
+	if filestoragewatch.Get() {
+		watchfile = filestoragepath.Get()
+	}
+
+	run(config.FileLoader(filestoragepath.Get()), newStateStore(statestoragepath.Get()),
+		newOutbox(outboxpath.Get()), watchfile, adminConfig{
+			Addr:  adminaddr.Get(),
+			Token: admintoken.Get(),
+			Health: healthConfig{
+				AuthFailures:  healthauthfailures.Get(),
+				StaleMultiple: healthstalemultiple.Get(),
+			},
+		})
+}
+
+func newStateStore(filepath string) email.StateStore {
+	if filepath == "" {
+		return email.NewMemoryStateStore()
+	}
+
+	states, err := email.NewFileStateStore(filepath)
+	if err != nil {
+		slog.Error("fail to load the mailbox states", "file", filepath, "err", err)
+		defaults.Exit(1)
+	}
+	return states
+}
+
+func newOutbox(filepath string) email.Outbox {
+	if filepath == "" {
+		return email.NewMemoryOutbox()
+	}
+
+	outbox, err := email.NewFileOutbox(filepath)
+	if err != nil {
+		slog.Error("fail to load the outbox", "file", filepath, "err", err)
+		defaults.Exit(1)
+	}
+	return outbox
 }
@@ -19,47 +19,82 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"os"
+	"os/signal"
 	"reflect"
+	"sort"
 	"sync"
 	"time"
 
+	_ "github.com/xgfone/emailmanager/pkg/notice/dingtalk"
 	_ "github.com/xgfone/emailmanager/pkg/notice/feishu"
+	_ "github.com/xgfone/emailmanager/pkg/notice/slack"
+	_ "github.com/xgfone/emailmanager/pkg/notice/smtp"
+	_ "github.com/xgfone/emailmanager/pkg/notice/telegram"
+	_ "github.com/xgfone/emailmanager/pkg/notice/webhook"
+	_ "github.com/xgfone/emailmanager/pkg/notice/wecom"
 
 	"github.com/xgfone/emailmanager/pkg/config"
 	"github.com/xgfone/emailmanager/pkg/controller"
+	"github.com/xgfone/emailmanager/pkg/email"
 	"github.com/xgfone/go-atexit"
 	"github.com/xgfone/go-defaults"
 )
 
-func run(loader config.Loader) {
-	m, err := newManager(loader)
+// run runs the manager until the program exits.
+//
+// If watchfile is not empty, reload the configs when it changes.
+// On Unix, the configs are also reloaded when receiving SIGHUP.
+//
+// If the address of admin is not empty, run the admin http server.
+func run(loader config.Loader, states email.StateStore, outbox email.Outbox, watchfile string, admin adminConfig) {
+	if err := admin.check(); err != nil {
+		slog.Error("invalid admin config", "err", err)
+		defaults.Exit(1)
+	}
+
+	m, err := newManager(loader, states, outbox)
 	if err != nil {
 		slog.Error("fail to new manager", "err", err)
 		defaults.Exit(1)
 	}
 
-	m.Start(atexit.Context())
+	ctx := atexit.Context()
+	m.Start(ctx)
+	go m.watch(ctx, watchfile)
+	if admin.Addr != "" {
+		go serveAdmin(ctx, m, admin)
+	}
 	atexit.Wait()
 }
 
 type ctrl struct {
 	cancel     context.CancelFunc
+	done       chan struct{}
 	config     config.Controller
 	controller *controller.Controller
 }
 
-func (c *ctrl) Run(ctx context.Context, interval time.Duration) {
-	if c.cancel == nil {
-		ctx, c.cancel = context.WithCancel(ctx)
-		c.controller.Run(ctx, interval)
-		slog.Info("controller has stopped")
-	} else {
-		slog.Warn("controller has been started")
+func (c *ctrl) Start(ctx context.Context, interval time.Duration) {
+	if c.cancel != nil {
+		slog.Warn("controller has been started", "controller", c.config.ID())
+		return
 	}
+
+	ctx, c.cancel = context.WithCancel(ctx)
+	c.done = make(chan struct{})
+	go func() {
+		defer close(c.done)
+		c.controller.Run(ctx, interval)
+		slog.Info("controller has stopped", "controller", c.config.ID())
+	}()
 }
 
+// Stop stops the controller gracefully, and waits until it has stopped.
 func (c *ctrl) Stop() {
+	c.controller.Stop()
 	if c.cancel != nil {
+		<-c.done
 		c.cancel()
 		c.cancel = nil
 	}
@@ -67,6 +102,8 @@ func (c *ctrl) Stop() {
 
 type manager struct {
 	loader config.Loader
+	states email.StateStore
+	outbox email.Outbox
 
 	lock    sync.RWMutex
 	ctrls   map[string]*ctrl
@@ -74,12 +111,52 @@ type manager struct {
 	cancel  context.CancelFunc
 }
 
-func newManager(loader config.Loader) (m *manager, err error) {
-	m = &manager{loader: loader, ctrls: make(map[string]*ctrl, 4)}
+func newManager(loader config.Loader, states email.StateStore, outbox email.Outbox) (m *manager, err error) {
+	m = &manager{loader: loader, states: states, outbox: outbox, ctrls: make(map[string]*ctrl, 4)}
 	err = m.sync()
 	return
 }
 
+// watch reloads the configs when watchfile changes or receiving SIGHUP,
+// until ctx is done.
+func (m *manager) watch(ctx context.Context, watchfile string) {
+	reloads := make(chan struct{}, 1)
+	reload := func() {
+		select {
+		case reloads <- struct{}{}:
+		default:
+		}
+	}
+
+	if watchfile != "" {
+		go func() {
+			if err := config.WatchFile(ctx, watchfile, time.Second, reload); err != nil {
+				slog.Error("fail to watch the config file", "file", watchfile, "err", err)
+			}
+		}()
+	}
+
+	signals := make(chan os.Signal, 1)
+	notifyReload(signals)
+	defer signal.Stop(signals)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case sig := <-signals:
+			slog.Info("receive the signal to reload the configs", "signal", sig.String())
+			reload()
+
+		case <-reloads:
+			if err := m.sync(); err != nil {
+				slog.Error("fail to reload the configs, and keep the old", "err", err)
+			}
+		}
+	}
+}
+
 func joinErrors(err1, err2 error) error {
 	if err1 == nil {
 		return err2
@@ -87,46 +164,143 @@ func joinErrors(err1, err2 error) error {
 	return errors.Join(err1, err2)
 }
 
+// sync loads the controller configs and applies them, which validates
+// all the configs first, and applies nothing if any of them is invalid.
 func (m *manager) sync() (err error) {
 	controllers, err := m.loader.LoadController()
 	if err != nil {
 		return fmt.Errorf("fail to load config from loader: %w", err)
 	}
 
+	type update struct {
+		key        string
+		config     config.Controller
+		options    []controller.Option
+		controller *controller.Controller // Only for the added controller
+	}
+
+	var added, changed, removed, failed []string
+	var unchanged int
+
 	m.lock.Lock()
-	defer m.lock.Unlock()
 
+	// 1. Validate all the changed configs.
+	keys := make(map[string]struct{}, len(controllers))
+	updates := make([]update, 0, len(controllers))
 	for _, c := range controllers {
-		if ctrl, ok := m.ctrls[c.Email.Address]; ok {
-			if !reflect.DeepEqual(ctrl.config, c) {
-				options, _err := c.Options()
-				if _err != nil {
-					_err = fmt.Errorf("fail to build controller options for %s: %w", c.Email.Address, _err)
-					err = joinErrors(err, _err)
-				} else {
-					_err = ctrl.controller.Reconfigure(options...)
-					if _err != nil {
-						err = joinErrors(err, _err)
-					}
-				}
-			}
-		} else {
-			if controller, _err := c.Controller(); _err != nil {
-				err = joinErrors(err, _err)
-			} else {
-				m.addController(controller, c)
+		key := c.ID()
+		if _, ok := keys[key]; ok {
+			err = joinErrors(err, fmt.Errorf("duplicate controller id '%s'", key))
+			continue
+		}
+		keys[key] = struct{}{}
+
+		ctrl, ok := m.ctrls[key]
+		if ok && reflect.DeepEqual(ctrl.config, c) {
+			unchanged++
+			continue
+		}
+
+		options, _err := c.Options()
+		if _err != nil {
+			_err = fmt.Errorf("fail to build controller options for %s: %w", key, _err)
+			err = joinErrors(err, _err)
+			continue
+		}
+		options = append(options, controller.StateStoreOption(m.states))
+		options = append(options, controller.OutboxOption(m.outbox))
+
+		// Build a controller to validate the options, which is discarded
+		// if the controller exists and will be reconfigured.
+		_controller, _err := controller.NewController(options...)
+		if _err != nil {
+			err = joinErrors(err, fmt.Errorf("invalid controller config for %s: %w", key, _err))
+			continue
+		}
+
+		u := update{key: key, config: c, options: options}
+		if !ok {
+			u.controller = _controller
+		}
+		updates = append(updates, u)
+	}
+
+	if err != nil {
+		m.lock.Unlock()
+		return
+	}
+
+	// 2. Apply the validated configs.
+	for _, u := range updates {
+		if u.controller != nil {
+			m.addController(u.controller, u.config)
+			added = append(added, u.key)
+			continue
+		}
+
+		ctrl := m.ctrls[u.key]
+		if _err := ctrl.controller.Reconfigure(u.options...); _err != nil {
+			slog.Error("fail to reconfigure controller, and keep the old",
+				"controller", u.key, "err", _err)
+			err = joinErrors(err, fmt.Errorf("fail to reconfigure controller %s: %w", u.key, _err))
+			failed = append(failed, u.key)
+			continue
+		}
+
+		ctrl.config = u.config
+		changed = append(changed, u.key)
+	}
+
+	stops := make([]*ctrl, 0, len(m.ctrls))
+	for key, ctrl := range m.ctrls {
+		if _, ok := keys[key]; !ok {
+			delete(m.ctrls, key)
+			stops = append(stops, ctrl)
+			removed = append(removed, key)
+		}
+	}
+	m.lock.Unlock()
+
+	// The mailbox states and the pending notices are keyed by the controller
+	// id, so they are lost if the controller of the same account is renamed.
+	for _, u := range updates {
+		if u.controller == nil {
+			continue
+		}
+
+		account := email.Account(u.config.Email.Address, u.config.Email.Username)
+		for _, c := range stops {
+			if email.Account(c.config.Email.Address, c.config.Email.Username) == account {
+				slog.Warn("controller is renamed, and its mailbox states and pending notices are not carried over",
+					"old", c.config.ID(), "new", u.key)
 			}
 		}
 	}
 
+	// Stop the removed controllers out of the lock,
+	// because it may wait for the in-flight checks to finish,
+	// then delete their metrics, which are never updated any more.
+	var wg sync.WaitGroup
+	for _, c := range stops {
+		wg.Add(1)
+		go func(c *ctrl) {
+			defer wg.Done()
+			c.Stop()
+			controller.DeleteMetrics(c.config.ID())
+		}(c)
+	}
+	wg.Wait()
+
+	slog.Info("sync controllers", "added", added, "changed", changed,
+		"removed", removed, "unchanged", unchanged, "failed", failed)
 	return
 }
 
 func (m *manager) addController(c *controller.Controller, config config.Controller) {
 	ctrl := &ctrl{controller: c, config: config}
-	m.ctrls[config.Email.Address] = ctrl
+	m.ctrls[config.ID()] = ctrl
 	if m.context != nil {
-		go ctrl.Run(m.context, 0)
+		ctrl.Start(m.context, 0)
 	}
 }
 
@@ -137,7 +311,42 @@ func (m *manager) Start(ctx context.Context) {
 	if m.context == nil {
 		m.context, m.cancel = context.WithCancel(ctx)
 		for _, ctrl := range m.ctrls {
-			go ctrl.Run(m.context, 0)
+			ctrl.Start(m.context, 0)
 		}
 	}
 }
+
+// Controllers returns all the controllers sorted by the id.
+func (m *manager) Controllers() []*controller.Controller {
+	m.lock.RLock()
+	controllers := make([]*controller.Controller, 0, len(m.ctrls))
+	for _, c := range m.ctrls {
+		controllers = append(controllers, c.controller)
+	}
+	m.lock.RUnlock()
+
+	sort.Slice(controllers, func(i, j int) bool {
+		return controllers[i].Name() < controllers[j].Name()
+	})
+	return controllers
+}
+
+// Controller returns the controller and its config by the id.
+func (m *manager) Controller(id string) (c *controller.Controller, conf config.Controller, ok bool) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	if ctrl, exist := m.ctrls[id]; exist {
+		c, conf, ok = ctrl.controller, ctrl.config, true
+	}
+	return
+}
+
+// Context returns the context to run the controllers.
+func (m *manager) Context() context.Context {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	if m.context == nil {
+		return context.Background()
+	}
+	return m.context
+}
@@ -22,17 +22,68 @@ import (
 	"github.com/xgfone/emailmanager/pkg/controller"
 	"github.com/xgfone/emailmanager/pkg/email"
 	"github.com/xgfone/emailmanager/pkg/notice"
+	"github.com/xgfone/go-binder"
 )
 
 // Builder is the builder config to build a notifier or handler.
 type Builder struct {
 	Configs map[string]interface{}
 	Type    string
+
+	// Name is only used by the notifier to identify it in the metrics,
+	// which must not contain the secrets.
+	//
+	// Default: Type
+	Name string
+
+	// Retry is only used by the notifier to retry to send the notice,
+	// which is bound to notice.RetryConfig, such as
+	//
+	//	{"MaxAttempts": 5, "InitialInterval": "1s", "MaxElapsedTime": "30s"}
+	//
+	// If nil, do not retry.
+	Retry map[string]interface{}
+
+	// Quiet is only used by the notifier to hold the emails in the quiet
+	// hours, which is bound to notice.QuietConfig, such as
+	//
+	//	{"Windows": [{"Start": "22:00", "End": "08:00"}], "Bypass": "subject contains 'PROD'"}
+	//
+	// If nil, send the notice at any time.
+	Quiet map[string]interface{}
 }
 
 // BuildNotifier builds a notifier.
-func (b Builder) BuildNotifier() (notice.Notifier, error) {
-	return notice.BuildNotifier(b.Type, b.Configs)
+func (b Builder) BuildNotifier() (notifier notice.Notifier, err error) {
+	notifier, err = notice.BuildNotifier(b.Type, b.Configs)
+	if err != nil {
+		return
+	}
+
+	if b.Retry != nil {
+		var retry notice.RetryConfig
+		if err = binder.BindStructToMap(&retry, "json", b.Retry); err != nil {
+			return nil, fmt.Errorf("invalid retry config: %w", err)
+		}
+		notifier = notice.NewRetryNotifier(notifier, retry)
+	}
+
+	if b.Quiet != nil {
+		var quiet notice.QuietConfig
+		if err = binder.BindStructToMap(&quiet, "json", b.Quiet); err != nil {
+			return nil, fmt.Errorf("invalid quiet config: %w", err)
+		}
+		if notifier, err = notice.NewQuietNotifier(notifier, quiet); err != nil {
+			return nil, fmt.Errorf("invalid quiet config: %w", err)
+		}
+	}
+
+	name := b.Name
+	if name == "" {
+		name = b.Type
+	}
+	notifier = notice.WithName(notifier, name)
+	return
 }
 
 // BuildEmailHandler builds an email handler.
@@ -40,6 +91,16 @@ func (b Builder) BuildEmailHandler() (email.Handler, error) {
 	return email.BuildHandler(b.Type, b.Configs)
 }
 
+// Mailbox is the mailbox config.
+type Mailbox struct {
+	// Name may contain the LIST-style wildcards, "*" and "%".
+	Name string `validate:"required"`
+
+	// If nil, use the handlers or notifiers of the controller instead.
+	Handlers  []Builder
+	Notifiers []Builder
+}
+
 // Email is the email config.
 type Email struct {
 	Address  string `validate:"required"`
@@ -49,6 +110,12 @@ type Email struct {
 	UseTLS   bool `json:"UseTls"`
 
 	SkipTLSVerify bool `validate:"SkipTlsVerify"`
+
+	// If true, fetch and decode the bodies of the emails.
+	FetchBody bool
+
+	// If empty, only watch the mailbox INBOX.
+	Mailboxes []Mailbox
 }
 
 // ControllerOptoin converts itself to the controller option.
@@ -58,51 +125,157 @@ func (e Email) ControllerOptoin() controller.Option {
 
 // Controller is the controller config.
 type Controller struct {
+	// Name is the unique name of the controller, which is used as its ID
+	// in logs, metrics and notices. Default: "Username@Address"
+	//
+	// Name is also the key of the UID states of the mailboxes and of the
+	// pending notices in the outbox. So renaming the controller, including
+	// setting or clearing Name, drops its states, that's, the mailboxes are
+	// resynced as the first check, and orphans its pending notices, which
+	// are never redelivered.
+	Name string
+
+	Idle     bool
 	Delay    int64
 	Timeout  int64
 	Interval int64
 
+	// Schedule is the cron expression or the named schedule, such as
+	// "every 1m during 09:00-19:00 Mon-Fri, every 30m otherwise",
+	// which takes precedence over Interval. See controller.ParseSchedule.
+	//
+	// Timezone is the IANA name of the location of Schedule,
+	// such as "Asia/Shanghai". Default: the local timezone.
+	Schedule string
+	Timezone string
+
 	Email     Email
 	Handlers  []Builder
 	Notifiers []Builder
+
+	// NotifyPolicy is one of "failover", "all" and "quorum:N", where N must
+	// not be greater than the number of Notifiers or those of any mailbox.
+	//
+	// Default: "failover"
+	NotifyPolicy string
+
+	// The pending notices in the outbox are dropped after failing to be
+	// redelivered for RedeliverMaxAttempts times, or after RedeliverMaxAge
+	// seconds since created.
+	//
+	// Default: 10, 86400
+	RedeliverMaxAttempts int
+	RedeliverMaxAge      int64
+}
+
+// ID returns the unique id of the controller, that's, Name if set,
+// or "Username@Address" instead.
+func (c Controller) ID() string {
+	if c.Name != "" {
+		return c.Name
+	}
+	return email.Account(c.Email.Address, c.Email.Username)
 }
 
 // Options converts itself to controller options.
 func (c Controller) Options() ([]controller.Option, error) {
 	options := make([]controller.Option, 0, 8)
+	options = append(options, controller.NameOption(c.ID()))
+	options = append(options, controller.IdleOption(c.Idle))
 	options = append(options, controller.DelayOption(time.Duration(c.Delay)*time.Second))
 	options = append(options, controller.TimeoutOption(time.Duration(c.Timeout)*time.Second))
 	options = append(options, controller.IntervalOption(time.Duration(c.Interval)*time.Second))
-	options = append(options, c.Email.ControllerOptoin())
+	if c.Schedule != "" {
+		loc := time.Local
+		if c.Timezone != "" {
+			var err error
+			if loc, err = time.LoadLocation(c.Timezone); err != nil {
+				return nil, fmt.Errorf("invalid timezone for %s: %w", c.ID(), err)
+			}
+		}
 
-	handlers := make([]email.Handler, len(c.Handlers))
-	for i, h := range c.Handlers {
-		handler, err := h.BuildEmailHandler()
+		schedule, err := controller.ParseSchedule(c.Schedule, loc)
 		if err != nil {
-			return nil, fmt.Errorf("fail to build email handler '%s' for %s: %w", h.Type, c.Email.Address, err)
+			return nil, fmt.Errorf("invalid schedule for %s: %w", c.ID(), err)
 		}
-		handlers[i] = handler
+		options = append(options, controller.ScheduleOption(schedule))
+	}
+	options = append(options, c.Email.ControllerOptoin())
+	options = append(options, controller.BodyOption(c.Email.FetchBody))
+
+	handlers, err := buildEmailHandlers(c.Handlers)
+	if err != nil {
+		return nil, fmt.Errorf("fail to build email handler for %s: %w", c.ID(), err)
 	}
 	options = append(options, controller.EmailHandlerOption(handlers...))
 
-	notifiers := make([]notice.Notifier, len(c.Notifiers))
-	for i, n := range c.Notifiers {
-		notifier, err := n.BuildNotifier()
+	notifiers, err := buildNotifiers(c.Notifiers)
+	if err != nil {
+		return nil, fmt.Errorf("fail to build notifier for %s: %w", c.ID(), err)
+	}
+	options = append(options, controller.NotifierOption(notifiers...))
+
+	policy, err := controller.ParseNotifyPolicy(c.NotifyPolicy)
+	if err != nil {
+		return nil, fmt.Errorf("fail to build notifier for %s: %w", c.ID(), err)
+	}
+	options = append(options, controller.NotifyPolicyOption(policy))
+	options = append(options, controller.RedeliverOption(c.RedeliverMaxAttempts,
+		time.Duration(c.RedeliverMaxAge)*time.Second))
+
+	mailboxes := make([]controller.Mailbox, len(c.Email.Mailboxes))
+	for i, mb := range c.Email.Mailboxes {
+		mailboxes[i].Name = mb.Name
+		mailboxes[i].Handlers, err = buildEmailHandlers(mb.Handlers)
+		if err != nil {
+			return nil, fmt.Errorf("fail to build email handler of mailbox '%s' for %s: %w", mb.Name, c.ID(), err)
+		}
+
+		mailboxes[i].Notifiers, err = buildNotifiers(mb.Notifiers)
 		if err != nil {
-			return nil, fmt.Errorf("fail to build notifier '%s' for %s: %w", n.Type, c.Email.Address, err)
+			return nil, fmt.Errorf("fail to build notifier of mailbox '%s' for %s: %w", mb.Name, c.ID(), err)
 		}
-		notifiers[i] = notifier
 	}
-	options = append(options, controller.NotifierOption(notifiers...))
+	options = append(options, controller.MailboxesOption(mailboxes...))
 
 	return options, nil
 }
 
+// buildEmailHandlers builds the email handlers, which returns nil if builders is nil.
+func buildEmailHandlers(builders []Builder) (handlers []email.Handler, err error) {
+	if builders == nil {
+		return
+	}
+
+	handlers = make([]email.Handler, len(builders))
+	for i, b := range builders {
+		if handlers[i], err = b.BuildEmailHandler(); err != nil {
+			return nil, fmt.Errorf("'%s': %w", b.Type, err)
+		}
+	}
+	return
+}
+
+// buildNotifiers builds the notifiers, which returns nil if builders is nil.
+func buildNotifiers(builders []Builder) (notifiers []notice.Notifier, err error) {
+	if builders == nil {
+		return
+	}
+
+	notifiers = make([]notice.Notifier, len(builders))
+	for i, b := range builders {
+		if notifiers[i], err = b.BuildNotifier(); err != nil {
+			return nil, fmt.Errorf("'%s': %w", b.Type, err)
+		}
+	}
+	return
+}
+
 // Controller creates a controller with itself.
 func (c Controller) Controller() (*controller.Controller, error) {
 	options, err := c.Options()
 	if err != nil {
-		return nil, fmt.Errorf("fail to build controller options for %s: %w", c.Email.Address, err)
+		return nil, fmt.Errorf("fail to build controller options for %s: %w", c.ID(), err)
 	}
 	return controller.NewController(options...)
 }
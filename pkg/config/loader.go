@@ -17,6 +17,7 @@ package config
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"os"
 
 	"github.com/xgfone/go-structs"
@@ -43,13 +44,21 @@ func (l fileLoader) LoadController() (controlers []Controller, err error) {
 	}
 
 	err = json.Unmarshal(removeLineComments(data), &controlers)
-	if err == nil {
-		for _, c := range controlers {
-			err = structs.Reflect(&c)
-			if err != nil {
-				return
-			}
+	if err != nil {
+		return
+	}
+
+	ids := make(map[string]struct{}, len(controlers))
+	for _, c := range controlers {
+		if err = structs.Reflect(&c); err != nil {
+			return
+		}
+
+		id := c.ID()
+		if _, ok := ids[id]; ok {
+			return nil, fmt.Errorf("duplicate controller id '%s'", id)
 		}
+		ids[id] = struct{}{}
 	}
 	return
 }
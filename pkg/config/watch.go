@@ -0,0 +1,84 @@
+// Copyright 2023 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"context"
+	"crypto/sha256"
+	"os"
+	"time"
+)
+
+// WatchFile watches the file until ctx is done, and calls onChange
+// once the content of the file has changed and does not change any more
+// within the debounce duration, which is 1s by default.
+//
+// On Linux, the directory of the file is watched by inotify, so it also
+// works if the file is replaced by renaming or is a symlink updated
+// atomically, such as the ConfigMap of Kubernetes. On other platforms,
+// or if inotify is unavailable, the file is polled every debounce instead.
+func WatchFile(ctx context.Context, path string, debounce time.Duration, onChange func()) error {
+	if path == "" {
+		panic("WatchFile: path must not be empty")
+	}
+	if onChange == nil {
+		panic("WatchFile: onChange must not be nil")
+	}
+	if debounce <= 0 {
+		debounce = time.Second
+	}
+
+	w := &fileWatcher{path: path, debounce: debounce, onChange: onChange}
+	w.digest, _ = fileDigest(path)
+	return w.watch(ctx)
+}
+
+type fileWatcher struct {
+	path     string
+	digest   [sha256.Size]byte
+	debounce time.Duration
+	onChange func()
+}
+
+// check calls onChange if the content of the file has changed.
+func (w *fileWatcher) check() {
+	// The file may be missing temporarily while being replaced.
+	if digest, err := fileDigest(w.path); err == nil && digest != w.digest {
+		w.digest = digest
+		w.onChange()
+	}
+}
+
+func (w *fileWatcher) poll(ctx context.Context) error {
+	ticker := time.NewTicker(w.debounce)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			w.check()
+		}
+	}
+}
+
+func fileDigest(path string) (digest [sha256.Size]byte, err error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		digest = sha256.Sum256(data)
+	}
+	return
+}
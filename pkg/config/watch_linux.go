@@ -0,0 +1,127 @@
+// Copyright 2023 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package config
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const inotifyMask = syscall.IN_MODIFY | syscall.IN_CLOSE_WRITE | syscall.IN_CREATE |
+	syscall.IN_DELETE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO
+
+func (w *fileWatcher) watch(ctx context.Context) error {
+	path, err := filepath.Abs(w.path)
+	if err != nil {
+		return err
+	}
+
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		slog.Warn("inotify is unavailable, and fall back to polling", "file", w.path, "err", err)
+		return w.poll(ctx)
+	}
+
+	// Watch the directory instead of the file, because the file may be
+	// replaced by renaming, which removes the watch of the old file.
+	dir, base := filepath.Split(path)
+	if _, err = syscall.InotifyAddWatch(fd, dir, inotifyMask); err != nil {
+		syscall.Close(fd)
+		slog.Warn("fail to watch the directory by inotify, and fall back to polling",
+			"file", w.path, "err", err)
+		return w.poll(ctx)
+	}
+
+	// The file is non-blocking, so Read is managed by the runtime poller,
+	// and Close can interrupt it.
+	file := os.NewFile(uintptr(fd), "inotify")
+	defer file.Close()
+
+	events := make(chan struct{}, 1)
+	errs := make(chan error, 1)
+	go func() { errs <- readInotifyEvents(file, base, events) }()
+
+	timer := time.NewTimer(w.debounce)
+	timer.Stop()
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case err := <-errs:
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+
+		case <-events:
+			timer.Reset(w.debounce)
+
+		case <-timer.C:
+			w.check()
+		}
+	}
+}
+
+// readInotifyEvents reads the inotify events, and notifies events
+// if the file named base, or the hidden entries starting with "..",
+// which are used by Kubernetes to update ConfigMap atomically, change.
+func readInotifyEvents(file *os.File, base string, events chan<- struct{}) error {
+	var buf [syscall.SizeofInotifyEvent * 64]byte
+	for {
+		n, err := file.Read(buf[:])
+		if err != nil {
+			return err
+		}
+
+		var changed bool
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			offset += syscall.SizeofInotifyEvent
+
+			end := offset + int(event.Len)
+			if end > n {
+				break
+			}
+			name := strings.TrimRight(string(buf[offset:end]), "\x00")
+			offset = end
+
+			if event.Mask&syscall.IN_IGNORED != 0 {
+				return errors.New("the watched directory has been removed")
+			}
+			if name == base || strings.HasPrefix(name, "..") {
+				changed = true
+			}
+		}
+
+		if changed {
+			select {
+			case events <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
@@ -19,8 +19,10 @@ package controller
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"log/slog"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -29,6 +31,16 @@ import (
 	"github.com/xgfone/go-defaults"
 )
 
+// NameOption returns an option about the unique name of the controller,
+// which is used in logs and notices, and as the key of the mailbox states
+// and the pending notices in the outbox, which are not carried over to
+// the new name if renamed.
+//
+// If not set, use "username@addr" of the email instead.
+func NameOption(name string) Option {
+	return func(c *config) { c.Name = name }
+}
+
 // EmailOption returns an option about email.
 //
 // Required: addr, username, password.
@@ -54,6 +66,12 @@ func EmailOption(addr, username, password string, enableTLS, skipTLSVerify bool,
 	}
 }
 
+// BodyOption returns an option about whether to fetch and decode
+// the bodies of the emails, which is disabled by default.
+func BodyOption(fetchBody bool) Option {
+	return func(c *config) { c.Body = fetchBody }
+}
+
 // EmailHandlerOption returns an option about email handler, which will append the handler.
 func EmailHandlerOption(handlers ...email.Handler) Option {
 	return func(c *config) {
@@ -63,13 +81,75 @@ func EmailHandlerOption(handlers ...email.Handler) Option {
 
 // NotifierOption returns an option about notifier, which will append the notifier.
 //
-// The notifier will be tries to send the notice in turn until someone does it successfully.
+// How the notifiers send the notice is decided by the notify policy,
+// see NotifyPolicyOption.
 func NotifierOption(notifiers ...notice.Notifier) Option {
 	return func(c *config) {
 		c.Notifiers = append([]notice.Notifier{}, notifiers...)
 	}
 }
 
+// Mailbox is the config of the mailbox to be watched.
+type Mailbox struct {
+	// Name is the name of the mailbox, which may contain the LIST-style
+	// wildcards, "*" and "%", to watch all the matched mailboxes.
+	Name string
+
+	// If nil, use the handlers or notifiers of the controller instead.
+	Handlers  []email.Handler
+	Notifiers []notice.Notifier
+}
+
+// MailboxesOption returns an option about the mailboxes to be watched.
+//
+// If not set, only watch the mailbox email.Inbox.
+func MailboxesOption(mailboxes ...Mailbox) Option {
+	return func(c *config) {
+		c.Mailboxes = append([]Mailbox{}, mailboxes...)
+	}
+}
+
+// StateStoreOption returns an option about the state store, which is used
+// to store the fetch states of the mailboxes to fetch the new emails only,
+// and the states are keyed by the name of the controller.
+//
+// If not set, use the state store based on the memory.
+func StateStoreOption(states email.StateStore) Option {
+	return func(c *config) { c.States = states }
+}
+
+// OutboxOption returns an option about the outbox, which is used to store
+// the pending notices owned by the name of the controller until they are
+// sent successfully, and the pending notices are redelivered in the background.
+// See RedeliverOption.
+//
+// If not set, use the outbox based on the memory.
+func OutboxOption(outbox email.Outbox) Option {
+	return func(c *config) { c.Outbox = outbox }
+}
+
+// RedeliverOption returns an option about the limits to redeliver
+// the pending notices in the outbox, which are dropped with an error log
+// after failing to be sent for maxAttempts times, or after maxAge since
+// they are created, or released if held by the notifier.
+//
+// If not set, use 10 and 24h instead.
+func RedeliverOption(maxAttempts int, maxAge time.Duration) Option {
+	return func(c *config) {
+		c.Redeliver.MaxAttempts = maxAttempts
+		c.Redeliver.MaxAge = maxAge
+	}
+}
+
+// IdleOption returns an option about whether to run in the IDLE mode,
+// which keeps a connection to the server and checks the new emails
+// once the server reports that the mailbox has changed.
+//
+// If the server does not support IDLE, fall back to the polling mode.
+func IdleOption(idle bool) Option {
+	return func(c *config) { c.Idle = idle }
+}
+
 // DelayOption returns a delay option.
 func DelayOption(delay time.Duration) Option {
 	return func(c *config) { c.Delay = delay }
@@ -81,6 +161,8 @@ func TimeoutOption(timeout time.Duration) Option {
 }
 
 // IntervalOption returns a interval option.
+//
+// It is ignored if the schedule is set by ScheduleOption.
 func IntervalOption(interval time.Duration) Option {
 	return func(c *config) { c.Interval = interval }
 }
@@ -93,6 +175,21 @@ type emailConfig struct {
 	TLSConf  *tls.Config
 }
 
+// equal reports whether both the email configs are the same,
+// which compares the TLS settings by value, not the pointers.
+func (c emailConfig) equal(other emailConfig) bool {
+	if c.Num != other.Num || c.Addr != other.Addr ||
+		c.Username != other.Username || c.Password != other.Password {
+		return false
+	}
+
+	if c.TLSConf == nil || other.TLSConf == nil {
+		return c.TLSConf == other.TLSConf
+	}
+	return c.TLSConf.ServerName == other.TLSConf.ServerName &&
+		c.TLSConf.InsecureSkipVerify == other.TLSConf.InsecureSkipVerify
+}
+
 func (c *emailConfig) check() error {
 	if c.Addr == "" || c.Username == "" || c.Password == "" {
 		return fmt.Errorf("email is not configured")
@@ -105,56 +202,173 @@ func (c *emailConfig) check() error {
 
 type config struct {
 	// Common
+	Name     string
+	Idle     bool
 	Delay    time.Duration
 	Timeout  time.Duration
 	Interval time.Duration
+	Schedule Schedule
 
 	// Email
-	Email    emailConfig
-	Handlers []email.Handler
+	Body      bool
+	Email     emailConfig
+	States    email.StateStore
+	Handlers  []email.Handler
+	Mailboxes []Mailbox
 
 	// Notifiers
+	Outbox    email.Outbox
+	Redeliver struct {
+		MaxAttempts int
+		MaxAge      time.Duration
+	}
+	Policy    NotifyPolicy
 	Notifiers []notice.Notifier
 }
 
+// reconfigure replaces the config with the new one built by the options,
+// whose unset fields are reset to the defaults, except for the state store
+// and outbox, which are kept if not set because they hold the states.
 func (c *config) reconfigure(options ...Option) error {
 	var new config
 	for _, option := range options {
 		option(&new)
 	}
 
-	c.merge(new)
-	return c.Email.check()
-}
+	if new.States == nil {
+		new.States = c.States
+	}
+	if new.Outbox == nil {
+		new.Outbox = c.Outbox
+	}
 
-func (c *config) merge(new config) {
-	if new.Delay > 0 {
-		c.Delay = new.Delay
+	new.setDefaults()
+	if err := new.Email.check(); err != nil {
+		return err
 	}
-	if new.Timeout > 0 {
-		c.Timeout = new.Timeout
+	if err := new.Policy.check(len(new.Notifiers)); err != nil {
+		return err
+	}
+	for _, mailbox := range new.Mailboxes {
+		if mailbox.Notifiers == nil {
+			continue
+		}
+		if err := new.Policy.check(len(mailbox.Notifiers)); err != nil {
+			return fmt.Errorf("mailbox '%s': %w", mailbox.Name, err)
+		}
 	}
 
-	var empty emailConfig
-	if new.Email != empty {
-		c.Email = new.Email
+	*c = new
+	return nil
+}
+
+func (c *config) setDefaults() {
+	if c.Name == "" {
+		c.Name = email.Account(c.Email.Addr, c.Email.Username)
+	}
+	if c.States == nil {
+		c.States = email.NewMemoryStateStore()
+	}
+	if c.Outbox == nil {
+		c.Outbox = email.NewMemoryOutbox()
 	}
+	if c.Policy.mode == "" {
+		c.Policy = PolicyFailover
+	}
+	if c.Redeliver.MaxAttempts <= 0 {
+		c.Redeliver.MaxAttempts = 10
+	}
+	if c.Redeliver.MaxAge <= 0 {
+		c.Redeliver.MaxAge = time.Hour * 24
+	}
+}
 
-	if new.Handlers != nil {
-		c.Handlers = new.Handlers
+// schedule returns the schedule to check the emails periodically.
+//
+// If not set, check them every interval, or every fallback
+// if interval is not set, or every 15m if fallback is 0.
+func (c config) schedule(fallback time.Duration) Schedule {
+	switch {
+	case c.Schedule != nil:
+		return c.Schedule
+	case c.Interval > 0:
+		return Every(c.Interval)
+	case fallback > 0:
+		return Every(fallback)
+	default:
+		return Every(time.Minute * 15)
 	}
+}
 
-	if new.Notifiers != nil {
-		c.Notifiers = new.Notifiers
+func (c *config) mailboxes() []Mailbox {
+	if len(c.Mailboxes) == 0 {
+		return []Mailbox{{Name: email.Inbox}}
 	}
+	return c.Mailboxes
+}
+
+// controllerStates is the state store keyed by the name of the controller,
+// not the email account, so that the controllers watching the same account
+// do not share the states.
+type controllerStates struct {
+	email.StateStore
+	name string
+}
+
+func (s controllerStates) LoadState(_, mailbox string) (email.MailboxState, error) {
+	return s.StateStore.LoadState(s.name, mailbox)
+}
+
+func (s controllerStates) SaveState(_, mailbox string, state email.MailboxState) error {
+	return s.StateStore.SaveState(s.name, mailbox, state)
 }
 
 // Option is used to configure the controller.
 type Option func(*config)
 
+// Status is the snapshot of the status of the controller.
+type Status struct {
+	Name    string
+	Paused  bool
+	Started time.Time // The time when the controller starts to run.
+
+	// Interval is the interval of the schedule after the last success,
+	// or after the start time if no success.
+	Interval time.Duration
+
+	// Running reports whether the controller is checking the emails.
+	Running bool
+
+	// Last is the result of the last check, which is nil if no check.
+	Last        *RunResult
+	LastSuccess time.Time // The end time of the last successful check.
+
+	// AuthFailures is the number of the consecutive failures to log in
+	// to the mail server, which is reset only after logging in successfully.
+	AuthFailures int
+}
+
 // Controller is used to control the check and notice of the new emails.
 type Controller struct {
-	config atomic.Value
+	config   atomic.Value
+	paused   atomic.Bool
+	fallback atomic.Int64 // The fallback interval passed to Run.
+
+	checking sync.Mutex
+	stopOnce sync.Once
+	stop     chan struct{}
+
+	// changed is closed and replaced each time reconfiguring.
+	lock    sync.Mutex
+	changed chan struct{}
+
+	running atomic.Bool
+	slock   sync.RWMutex
+	status  Status
+	history history
+
+	// triggered reports whether the check triggered by TriggerCheck is in flight.
+	triggered atomic.Bool
 }
 
 // NewController returns a new controller.
@@ -163,8 +377,7 @@ func NewController(options ...Option) (*Controller, error) {
 	if err := config.reconfigure(options...); err != nil {
 		return nil, err
 	}
-
-	c := new(Controller)
+	c := &Controller{stop: make(chan struct{}), changed: make(chan struct{})}
 	c.saveConfig(config)
 	return c, nil
 }
@@ -172,48 +385,226 @@ func NewController(options ...Option) (*Controller, error) {
 func (c *Controller) loadConfig() config       { return c.config.Load().(config) }
 func (c *Controller) saveConfig(config config) { c.config.Store(config) }
 
-// Reconfigure reconfigures the controller.
+// Name returns the unique name of the controller.
+func (c *Controller) Name() string { return c.loadConfig().Name }
+
+// Status returns the snapshot of the status of the controller.
+func (c *Controller) Status() Status {
+	c.slock.RLock()
+	status := c.status
+	if last, ok := c.history.last(); ok {
+		status.Last = &last
+	}
+	c.slock.RUnlock()
+
+	config := c.loadConfig()
+	status.Name = config.Name
+	status.Paused = c.Paused()
+	status.Running = c.running.Load()
+
+	base := status.LastSuccess
+	if base.IsZero() {
+		base = status.Started
+	}
+	if !base.IsZero() {
+		schedule := config.schedule(time.Duration(c.fallback.Load()))
+		status.Interval = schedule.Next(base).Sub(base)
+	}
+
+	return status
+}
+
+// dial connects to the mail server, and records the authentication failures.
+func (c *Controller) dial(config config) (*email.Conn, error) {
+	conn, err := email.Dial(config.Email.Addr, config.Email.Username,
+		config.Email.Password, config.Email.TLSConf)
+
+	// The transport errors, such as the timeout, do not tell
+	// whether the credentials are valid, so keep the count.
+	c.slock.Lock()
+	switch {
+	case err == nil:
+		c.status.AuthFailures = 0
+	case errors.Is(err, email.ErrAuthFailed):
+		c.status.AuthFailures++
+	}
+	c.slock.Unlock()
+
+	return conn, err
+}
+
+// History returns the results of the recent checks, at most MaxHistory,
+// from the oldest to the newest.
+func (c *Controller) History() []RunResult {
+	c.slock.RLock()
+	defer c.slock.RUnlock()
+	return c.history.list()
+}
+
+func (c *Controller) record(name string, result RunResult) {
+	if len(result.Errors) == 0 {
+		lastSuccessTime.Set(float64(result.End.Unix()), name)
+	}
+
+	c.slock.Lock()
+	defer c.slock.Unlock()
+
+	c.history.add(result)
+	if len(result.Errors) == 0 {
+		c.status.LastSuccess = result.End
+	}
+}
+
+// Pause pauses the scheduled checks of the emails until Resume is called,
+// but the controller keeps running and CheckEmails still works.
+func (c *Controller) Pause() { c.paused.Store(true) }
+
+// Resume resumes the scheduled checks of the emails paused by Pause.
+func (c *Controller) Resume() { c.paused.Store(false) }
+
+// Paused reports whether the scheduled checks of the emails are paused.
+func (c *Controller) Paused() bool { return c.paused.Load() }
+
+// Reconfigure reconfigures the controller with the options,
+// which takes effect from the next check, including the interval.
+//
+// The unset options are reset to the defaults, except for the state store
+// and outbox, which are kept.
 func (c *Controller) Reconfigure(options ...Option) (err error) {
 	config := c.loadConfig()
 	if err = config.reconfigure(options...); err == nil {
 		c.saveConfig(config)
+
+		c.lock.Lock()
+		close(c.changed)
+		c.changed = make(chan struct{})
+		c.lock.Unlock()
 	}
 	return
 }
 
-// Run runs until ctx is done.
+// reconfigured returns a channel which is closed when reconfiguring next time.
+func (c *Controller) reconfigured() <-chan struct{} {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.changed
+}
+
+// Stop stops the controller gracefully, which does not interrupt
+// the in-flight check but waits for it to finish, then Run returns
+// without starting any new check.
+func (c *Controller) Stop() {
+	c.stopOnce.Do(func() { close(c.stop) })
+	c.checking.Lock()
+	defer c.checking.Unlock()
+}
+
+func (c *Controller) stopped() bool {
+	select {
+	case <-c.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// Run runs until ctx is done or the controller is stopped,
+// and redelivers the pending notices in the outbox in the background.
+//
+// interval is used only if the interval of the controller is not set.
 func (c *Controller) Run(ctx context.Context, interval time.Duration) {
+	c.fallback.Store(int64(interval))
+	started := time.Now()
+	c.slock.Lock()
+	c.status.Started = started
+	c.slock.Unlock()
+
+	// Wait for the redelivery to stop before returning,
+	// so that no notice is being sent after the controller stops.
+	redelivered := make(chan struct{})
+	defer func() { <-redelivered }()
+	go func() {
+		defer close(redelivered)
+		c.runRedeliver(ctx, started)
+	}()
+
 	if !c.firstRun(ctx) {
 		return
 	}
 
-	cinterval := c.loadConfig().Interval
-	if cinterval <= 0 {
-		if cinterval = interval; cinterval <= 0 {
-			cinterval = time.Minute * 15
+	// The emails have just been checked by the first run.
+	for checked := true; ; checked = false {
+		if c.loadConfig().Idle && c.runIdle(ctx, interval, checked) {
+			return
+		}
+		if !c.runPoll(ctx, interval) {
+			return
 		}
 	}
+}
 
-	ticker := time.NewTicker(cinterval)
-	defer ticker.Stop()
+// runPoll checks the emails by the schedule, and rebuilds the timer
+// when the schedule is changed by reconfiguring.
+//
+// It returns true if the IDLE mode is enabled by reconfiguring,
+// and the caller should switch to the IDLE mode.
+func (c *Controller) runPoll(ctx context.Context, fallback time.Duration) bool {
+	config := c.loadConfig()
+	idle := config.Idle
+	schedule := config.schedule(fallback)
+	changed := c.reconfigured()
+
+	timer := time.NewTimer(time.Until(schedule.Next(time.Now())))
+	defer timer.Stop()
 
 	for {
 		select {
 		case <-ctx.Done():
-			return
+			return false
+
+		case <-c.stop:
+			return false
 
-		case <-ticker.C:
-			c.CheckEmails(ctx)
+		case <-changed:
+			changed = c.reconfigured()
+			config = c.loadConfig()
+			if config.Idle && !idle {
+				return true
+			}
+			idle = config.Idle
+
+			if newSchedule := config.schedule(fallback); newSchedule.String() != schedule.String() {
+				slog.Info("check schedule has changed", "controller", config.Name,
+					"old", schedule.String(), "new", newSchedule.String())
+				schedule = newSchedule
+				if !timer.Stop() {
+					<-timer.C
+				}
+				timer.Reset(time.Until(schedule.Next(time.Now())))
+			}
+
+		case <-timer.C:
+			if !c.Paused() {
+				c.CheckEmails(ctx)
+			}
+			timer.Reset(time.Until(schedule.Next(time.Now())))
 		}
 	}
 }
 
 func (c *Controller) firstRun(ctx context.Context) (next bool) {
+	if c.stopped() {
+		return
+	}
+
 	config := c.loadConfig()
 	if config.Delay > 0 {
 		timer := time.NewTimer(config.Delay)
 		select {
 		case <-timer.C:
+		case <-c.stop:
+			timer.Stop()
+			return
 		case <-ctx.Done():
 			if !timer.Stop() {
 				select {
@@ -224,50 +615,193 @@ func (c *Controller) firstRun(ctx context.Context) (next bool) {
 			return
 		}
 	}
-	c.CheckEmails(ctx)
-	return true
+
+	if !c.Paused() {
+		c.CheckEmails(ctx)
+	}
+	return !c.stopped()
 }
 
 // CheckEmails checks all the emails immediately.
+//
+// The checks of the same controller are serialized.
 func (c *Controller) CheckEmails(ctx context.Context) {
-	for c.checkEmails(ctx) {
+	for {
+		if goon, _ := c.checkEmails(ctx, nil); !goon || c.stopped() {
+			break
+		}
+	}
+}
+
+// TriggerCheck checks the emails like CheckEmails in the background,
+// and returns false without checking if the last triggered check
+// has not finished.
+func (c *Controller) TriggerCheck(ctx context.Context) bool {
+	if !c.triggered.CompareAndSwap(false, true) {
+		return false
+	}
+
+	go func() {
+		defer c.triggered.Store(false)
+		c.CheckEmails(ctx)
+	}()
+	return true
+}
+
+// GetMailBoxes connects to the mail server like the checks, and returns
+// the mailboxes matching the pattern. See email.Conn.GetMailBoxes.
+func (c *Controller) GetMailBoxes(ctx context.Context, pattern string) ([]email.Mailbox, error) {
+	conn, err := c.dial(c.loadConfig())
+	if err != nil {
+		return nil, err
 	}
+	defer conn.Close()
+	return conn.GetMailBoxes(ctx, pattern)
 }
 
-func (c *Controller) checkEmails(ctx context.Context) (goon bool) {
-	defer defaults.Recover(ctx)
+// checkEmails checks the emails of all the mailboxes by conn.
+//
+// If conn is nil, connect to the server and close it after checking.
+func (c *Controller) checkEmails(ctx context.Context, conn *email.Conn) (goon bool, err error) {
+	c.checking.Lock()
+	defer c.checking.Unlock()
+	if c.stopped() {
+		return
+	}
+
 	defer slog.Info("end to check the emails")
 	slog.Info("start to check the emails")
 
 	config := c.loadConfig()
+	result := &RunResult{Start: time.Now()}
+	c.running.Store(true)
+	defer func() {
+		// Recover the panic here, not by defaults.Recover, to record it as a failure.
+		if r := recover(); r != nil {
+			defaults.HandlePanicContext(ctx, r)
+			goon, err = false, errors.Join(err, fmt.Errorf("panic: %v", r))
+		}
+
+		c.running.Store(false)
+		result.End = time.Now()
+		result.Goon = goon
+		result.setError(err)
+		c.record(config.Name, *result)
+	}()
+
+	ctx = notice.WithController(ctx, config.Name)
 	if config.Timeout > 0 {
 		var cancel func()
 		ctx, cancel = context.WithTimeout(ctx, config.Timeout)
 		defer cancel()
 	}
 
-	emails, goon, err := email.FetchEmails(ctx, config.Email.Addr,
-		config.Email.Username, config.Email.Password, email.Inbox,
-		config.Email.TLSConf, config.Email.Num, config.Handlers...)
-	if err != nil {
-		slog.Error("fail to fetch emails", "addr", config.Email.Addr,
-			"email", config.Email.Username, "mailbox", email.Inbox, "err", err)
-		return
-	} else if len(emails) == 0 {
-		slog.Debug("no emails to be sent")
-		return
+	if conn == nil {
+		conn, err = c.dial(config)
+		if err != nil {
+			fetchAttempts.Inc(config.Name)
+			fetchErrors.Inc(config.Name)
+			slog.Error("fail to connect to the mail server", "addr", config.Email.Addr,
+				"controller", config.Name, "err", err)
+			return
+		}
+		defer conn.Close()
 	}
 
-	for _, notifier := range config.Notifiers {
-		if err := notifier.Notify(ctx, emails...); err != nil {
-			slog.Error("fail to send notice", "email", config.Email.Username,
-				"notifier", notifier.String(), "err", err)
-		} else {
-			slog.Info("send new email notice", "email", config.Email.Username,
-				"notifier", notifier.String())
-			break
+	for _, mailbox := range config.mailboxes() {
+		_goon, _err := c.checkMailbox(ctx, conn, config, mailbox, result)
+		if _err != nil {
+			err = errors.Join(err, _err)
+		}
+		goon = goon || _goon
+	}
+	lastCheckUnread.Set(float64(result.Unread), config.Name)
+
+	return
+}
+
+// checkMailbox checks the new emails of the mailbox,
+// and records the numbers of the emails and the notifiers into result.
+func (c *Controller) checkMailbox(ctx context.Context, conn *email.Conn,
+	config config, mailbox Mailbox, result *RunResult) (goon bool, err error) {
+
+	mailboxes := []string{mailbox.Name}
+	if email.IsWildcardMailbox(mailbox.Name) {
+		boxes, err := conn.GetMailBoxes(ctx, mailbox.Name)
+		if err != nil {
+			slog.Error("fail to list mailboxes", "addr", config.Email.Addr,
+				"controller", config.Name, "mailbox", mailbox.Name, "err", err)
+			return false, err
 		}
+
+		mailboxes = mailboxes[:0]
+		for _, box := range boxes {
+			if !box.NoSelect {
+				mailboxes = append(mailboxes, box.Name)
+			}
+		}
+	}
+
+	handlers := mailbox.Handlers
+	if handlers == nil {
+		handlers = config.Handlers
+	}
+	handlers = instrumentHandlers(config.Name, &result.Fetched, handlers)
+
+	notifiers := mailbox.Notifiers
+	if notifiers == nil {
+		notifiers = config.Notifiers
+	}
+
+	var emails []email.Email
+	for _, name := range mailboxes {
+		start := time.Now()
+		_emails, _goon, _err := conn.FetchNewEmails(ctx, name, config.Body, config.Email.Num,
+			controllerStates{StateStore: config.States, name: config.Name}, handlers...)
+		fetchDuration.Observe(time.Since(start).Seconds(), config.Name)
+		fetchAttempts.Inc(config.Name)
+		if _err != nil {
+			fetchErrors.Inc(config.Name)
+			slog.Error("fail to fetch emails", "addr", config.Email.Addr,
+				"controller", config.Name, "mailbox", name, "err", _err)
+			err = errors.Join(err, _err)
+			continue
+		}
+
+		goon = goon || _goon
+		emails = append(emails, _emails...)
+	}
+
+	result.Passed += len(emails)
+	for i := range emails {
+		if !emails[i].IsRead() {
+			result.Unread++
+		}
+	}
+
+	if len(emails) == 0 {
+		slog.Debug("no emails to be sent", "mailbox", mailbox.Name)
+		return
+	}
+
+	entry := email.OutboxEntry{
+		ID:      newOutboxID(),
+		Owner:   config.Name,
+		Mailbox: mailbox.Name,
+		Emails:  emails,
+		Created: time.Now(),
+	}
+
+	if _err := config.Outbox.Put(entry); _err != nil {
+		slog.Error("fail to save the pending notice into outbox", "controller", config.Name,
+			"mailbox", mailbox.Name, "id", entry.ID, "err", _err)
+	}
+
+	sent, _err := deliver(ctx, config, notifiers, entry)
+	if _err != nil {
+		err = errors.Join(err, _err)
 	}
+	result.addNotifiers(sent...)
 
 	return
 }
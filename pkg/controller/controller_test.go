@@ -0,0 +1,40 @@
+// Copyright 2023 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import "testing"
+
+func TestEmailConfigEqual(t *testing.T) {
+	emailConfigOf := func(enableTLS, skipTLSVerify bool) (c config) {
+		EmailOption("imap.example.com:993", "user", "pass", enableTLS, skipTLSVerify, 0)(&c)
+		return
+	}
+
+	tests := []struct {
+		c1, c2 config
+		equal  bool
+	}{
+		{emailConfigOf(true, false), emailConfigOf(true, false), true},
+		{emailConfigOf(false, false), emailConfigOf(false, false), true},
+		{emailConfigOf(true, false), emailConfigOf(true, true), false},
+		{emailConfigOf(true, false), emailConfigOf(false, false), false},
+	}
+
+	for i, test := range tests {
+		if equal := test.c1.Email.equal(test.c2.Email); equal != test.equal {
+			t.Errorf("%d: expect %v, but got %v", i, test.equal, equal)
+		}
+	}
+}
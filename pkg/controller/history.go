@@ -0,0 +1,98 @@
+// Copyright 2023 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"slices"
+	"time"
+)
+
+// MaxHistory is the maximum number of the run results kept by the controller.
+const MaxHistory = 32
+
+// RunResult is the result of a run to check the emails.
+type RunResult struct {
+	Start time.Time
+	End   time.Time
+
+	Fetched int // The number of the fetched emails.
+	Passed  int // The number of the emails passing through the handlers.
+	Unread  int // The number of the unread emails in the passed.
+
+	// Notifiers are the notifiers which have sent the notices successfully.
+	Notifiers []string
+	Errors    []string
+
+	// Goon reports whether there are more new emails to be checked,
+	// and the check is looped immediately.
+	Goon bool
+}
+
+// Duration returns the duration of the run.
+func (r RunResult) Duration() time.Duration { return r.End.Sub(r.Start) }
+
+func (r *RunResult) addNotifiers(notifiers ...string) {
+	for _, notifier := range notifiers {
+		if !slices.Contains(r.Notifiers, notifier) {
+			r.Notifiers = append(r.Notifiers, notifier)
+		}
+	}
+}
+
+func (r *RunResult) setError(err error) {
+	if err == nil {
+		return
+	}
+
+	if errs, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, err := range errs.Unwrap() {
+			r.Errors = append(r.Errors, err.Error())
+		}
+	} else {
+		r.Errors = append(r.Errors, err.Error())
+	}
+}
+
+// history is a ring buffer of the run results.
+type history struct {
+	results []RunResult
+	next    int
+}
+
+func (h *history) add(result RunResult) {
+	if len(h.results) < MaxHistory {
+		h.results = append(h.results, result)
+	} else {
+		h.results[h.next] = result
+	}
+	h.next = (h.next + 1) % MaxHistory
+}
+
+func (h *history) last() (result RunResult, ok bool) {
+	if len(h.results) == 0 {
+		return
+	}
+	return h.results[(h.next+MaxHistory-1)%MaxHistory], true
+}
+
+// list returns the run results from the oldest to the newest.
+func (h *history) list() []RunResult {
+	results := make([]RunResult, 0, len(h.results))
+	if len(h.results) < MaxHistory {
+		return append(results, h.results...)
+	}
+	results = append(results, h.results[h.next:]...)
+	return append(results, h.results[:h.next]...)
+}
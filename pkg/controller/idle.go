@@ -0,0 +1,172 @@
+// Copyright 2023 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/xgfone/emailmanager/pkg/email"
+)
+
+const maxIdleBackoff = time.Minute * 5
+
+// runIdle runs in the IDLE mode until ctx is done, and reconnects
+// to the server with the exponential backoff if the connection is broken.
+//
+// The emails are also checked by the schedule even if the server does not
+// report any change, and fallback is used as the interval if neither
+// the schedule nor the interval of the controller is set.
+//
+// If checked is true, the emails have just been checked, and are not checked
+// again before the first IDLE, but still after reconnecting.
+//
+// It returns false if the server does not support IDLE or the IDLE mode
+// is disabled by reconfiguring, and the caller should fall back to polling.
+func (c *Controller) runIdle(ctx context.Context, fallback time.Duration, checked bool) bool {
+	backoff := time.Second
+	for {
+		if c.stopped() {
+			return true
+		}
+
+		config := c.loadConfig()
+		if !config.Idle {
+			slog.Info("idle mode is disabled, and fall back to polling")
+			return false
+		}
+
+		start := time.Now()
+		supported, err := c.idle(ctx, config, fallback, checked)
+		checked = false
+		switch {
+		case ctx.Err() != nil:
+			return true
+
+		case !supported:
+			slog.Warn("server does not support IDLE, and fall back to polling",
+				"addr", config.Email.Addr, "controller", config.Name)
+			return false
+
+		case err == nil:
+			// The email config has been changed, and reconnect immediately.
+			continue
+		}
+
+		if time.Since(start) > maxIdleBackoff {
+			backoff = time.Second
+		}
+
+		slog.Error("idle connection is broken, and reconnect later",
+			"addr", config.Email.Addr, "controller", config.Name,
+			"backoff", backoff, "err", err)
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-c.stop:
+			timer.Stop()
+			return true
+		case <-ctx.Done():
+			timer.Stop()
+			return true
+		}
+
+		if backoff *= 2; backoff > maxIdleBackoff {
+			backoff = maxIdleBackoff
+		}
+	}
+}
+
+// idle keeps a connection to the server and checks the emails each time
+// the server reports the change of the mailbox, until the connection is
+// broken, ctx is done, or the email config or IDLE mode is changed.
+//
+// Only the first mailbox is watched by IDLE, or Inbox if it contains
+// the wildcards, and all the mailboxes are checked when it has changed
+// or by the schedule. If checked is true, skip the check before the first IDLE.
+func (c *Controller) idle(ctx context.Context, conf config, fallback time.Duration, checked bool) (supported bool, err error) {
+	conn, err := c.dial(conf)
+	if err != nil {
+		return true, err
+	}
+	defer conn.Close()
+
+	if supported, err = conn.SupportIdle(); err != nil {
+		return true, err
+	} else if !supported {
+		return
+	}
+
+	mailbox := conf.mailboxes()[0].Name
+	if email.IsWildcardMailbox(mailbox) {
+		mailbox = email.Inbox
+	}
+
+	slog.Info("start to idle", "addr", conf.Email.Addr,
+		"controller", conf.Name, "mailbox", mailbox)
+	for check := !checked; ; check = true {
+		for goon := check && !c.Paused(); goon && !c.stopped(); {
+			goon, err = c.checkEmails(ctx, conn)
+			if conn.Closed() {
+				if err == nil {
+					err = errors.New("connection has been closed")
+				}
+				return
+			}
+		}
+
+		newconf := c.loadConfig()
+		if c.stopped() || !newconf.Idle || !newconf.Email.equal(conf.Email) {
+			return true, nil
+		}
+
+		timeout := time.Until(newconf.schedule(fallback).Next(time.Now()))
+		idlectx, cancel := c.waitContext(ctx, timeout)
+		err = conn.Idle(idlectx, mailbox)
+		cancel()
+
+		switch {
+		case ctx.Err() != nil:
+			return true, ctx.Err()
+		case c.stopped():
+			return true, nil
+		case errors.Is(err, context.Canceled):
+			// Reconfigured, and check whether the config has changed.
+			err = nil
+		case err != nil && !errors.Is(err, context.DeadlineExceeded):
+			return
+		}
+	}
+}
+
+// waitContext returns a child context of ctx with the timeout, which is
+// also canceled when the controller is stopped or reconfigured.
+func (c *Controller) waitContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	changed := c.reconfigured()
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	go func() {
+		select {
+		case <-c.stop:
+			cancel()
+		case <-changed:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
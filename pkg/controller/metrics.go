@@ -0,0 +1,92 @@
+// Copyright 2023 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"github.com/xgfone/emailmanager/pkg/email"
+	"github.com/xgfone/emailmanager/pkg/metrics"
+)
+
+var (
+	fetchAttempts = metrics.NewCounter("emailmanager_fetch_attempts_total",
+		"The total number of the attempts to fetch the emails from the mailboxes.", "controller")
+	fetchErrors = metrics.NewCounter("emailmanager_fetch_errors_total",
+		"The total number of the failed attempts to fetch the emails from the mailboxes.", "controller")
+	fetchDuration = metrics.NewHistogram("emailmanager_fetch_duration_seconds",
+		"The duration in seconds to fetch the emails from a mailbox.", nil, "controller")
+
+	emailsFetched = metrics.NewCounter("emailmanager_emails_fetched_total",
+		"The total number of the fetched emails before being handled.", "controller")
+	emailsFiltered = metrics.NewCounter("emailmanager_emails_filtered_total",
+		"The total number of the emails filtered out by the handlers.", "controller", "handler")
+	handlerErrors = metrics.NewCounter("emailmanager_handler_errors_total",
+		"The total number of the errors returned by the handlers.", "controller", "handler")
+
+	notifySuccesses = metrics.NewCounter("emailmanager_notify_successes_total",
+		"The total number of the notices sent successfully by the notifiers.", "controller", "notifier")
+	notifyFailures = metrics.NewCounter("emailmanager_notify_failures_total",
+		"The total number of the notices which the notifiers failed to send.", "controller", "notifier")
+	notifyDuration = metrics.NewHistogram("emailmanager_notify_duration_seconds",
+		"The duration in seconds for the notifier to send a notice.", nil, "controller", "notifier")
+
+	lastSuccessTime = metrics.NewGauge("emailmanager_last_success_timestamp_seconds",
+		"The unix timestamp in seconds when the emails were checked successfully last time.", "controller")
+	lastCheckUnread = metrics.NewGauge("emailmanager_last_check_unread_emails",
+		"The number of the new unread emails to be noticed by the last check, not all the unread emails in the mailboxes.", "controller")
+)
+
+// DeleteMetrics deletes all the metric series of the controller named name,
+// which should be called after the controller is removed.
+func DeleteMetrics(name string) {
+	for _, c := range []metrics.Counter{fetchAttempts, fetchErrors, emailsFetched,
+		emailsFiltered, handlerErrors, notifySuccesses, notifyFailures} {
+		c.DeleteLabel("controller", name)
+	}
+	for _, h := range []metrics.Histogram{fetchDuration, notifyDuration} {
+		h.DeleteLabel("controller", name)
+	}
+	lastSuccessTime.DeleteLabel("controller", name)
+	lastCheckUnread.DeleteLabel("controller", name)
+}
+
+// instrumentHandlers returns the new handlers wrapping the handlers,
+// which count the fetched emails, also added into fetched,
+// and the emails filtered by each handler.
+func instrumentHandlers(controller string, fetched *int, handlers []email.Handler) []email.Handler {
+	_handlers := make([]email.Handler, 0, len(handlers)+1)
+	_handlers = append(_handlers, email.NewHandler("metrics", func(*email.Email) (bool, error) {
+		emailsFetched.Inc(controller)
+		*fetched++
+		return true, nil
+	}))
+
+	for _, handler := range handlers {
+		_handlers = append(_handlers, instrumentHandler(controller, handler))
+	}
+	return _handlers
+}
+
+func instrumentHandler(controller string, handler email.Handler) email.Handler {
+	_type := handler.Type()
+	return email.NewHandler(_type, func(e *email.Email) (next bool, err error) {
+		next, err = handler.Handle(e)
+		if err != nil {
+			handlerErrors.Inc(controller, _type)
+		} else if !next {
+			emailsFiltered.Inc(controller, _type)
+		}
+		return
+	})
+}
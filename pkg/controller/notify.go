@@ -0,0 +1,229 @@
+// Copyright 2023 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xgfone/emailmanager/pkg/email"
+	"github.com/xgfone/emailmanager/pkg/notice"
+)
+
+// Predefine some notify policies.
+var (
+	// PolicyFailover tries the notifiers in turn until someone sends
+	// the notice successfully, which is the default.
+	PolicyFailover = NotifyPolicy{mode: "failover"}
+
+	// PolicyAll sends the notice by all the notifiers concurrently,
+	// and succeeds only if all of them succeed.
+	PolicyAll = NotifyPolicy{mode: "all"}
+)
+
+// PolicyQuorum returns a notify policy which sends the notice by all
+// the notifiers concurrently, and succeeds if at least n of them succeed.
+func PolicyQuorum(n int) NotifyPolicy {
+	if n <= 0 {
+		panic("PolicyQuorum: n must be greater than 0")
+	}
+	return NotifyPolicy{mode: "quorum", quorum: n}
+}
+
+// NotifyPolicy is the policy about how to send the notice by the notifiers.
+type NotifyPolicy struct {
+	mode   string
+	quorum int
+}
+
+// ParseNotifyPolicy parses the notify policy from s,
+// which is one of "failover", "all" and "quorum:N".
+//
+// If s is empty, return PolicyFailover.
+func ParseNotifyPolicy(s string) (NotifyPolicy, error) {
+	switch s = strings.TrimSpace(s); s {
+	case "", PolicyFailover.mode:
+		return PolicyFailover, nil
+	case PolicyAll.mode:
+		return PolicyAll, nil
+	}
+
+	if n, ok := strings.CutPrefix(s, "quorum:"); ok {
+		if quorum, err := strconv.Atoi(strings.TrimSpace(n)); err == nil && quorum > 0 {
+			return PolicyQuorum(quorum), nil
+		}
+	}
+
+	return NotifyPolicy{}, fmt.Errorf("invalid notify policy '%s'", s)
+}
+
+// String returns the string representation of the notify policy.
+func (p NotifyPolicy) String() string {
+	switch p.mode {
+	case "":
+		return PolicyFailover.mode
+	case "quorum":
+		return fmt.Sprintf("quorum:%d", p.quorum)
+	default:
+		return p.mode
+	}
+}
+
+// check checks whether the policy can be satisfied by n notifiers.
+func (p NotifyPolicy) check(n int) error {
+	if p.mode == "quorum" && n > 0 && p.quorum > n {
+		return fmt.Errorf("notify policy '%s' requires more than the %d notifiers", p, n)
+	}
+	return nil
+}
+
+// NotifyPolicyOption returns an option about the notify policy.
+//
+// If not set, use PolicyFailover. The quorum must not be greater than
+// the number of the notifiers, including those of each mailbox.
+func NotifyPolicyOption(policy NotifyPolicy) Option {
+	return func(c *config) { c.Policy = policy }
+}
+
+// notifierKeys returns the keys identifying the notifiers in the outbox,
+// which are their descriptions, suffixed by "#N" for the Nth duplicate.
+func notifierKeys(notifiers []notice.Notifier) []string {
+	keys := make([]string, len(notifiers))
+	counts := make(map[string]int, len(notifiers))
+	for i, notifier := range notifiers {
+		key := notifier.String()
+		if counts[key]++; counts[key] > 1 {
+			key = fmt.Sprintf("%s#%d", key, counts[key])
+		}
+		keys[i] = key
+	}
+	return keys
+}
+
+// notifyResult is the outcome of a notifier to send the notice.
+type notifyResult struct {
+	Done bool              // Sent or held before, which is not tried again.
+	Sent bool              // Sent successfully this time.
+	Held *notice.HeldError // Held a part of the emails this time.
+	Err  error             // Failed this time.
+}
+
+func (r notifyResult) done() bool { return r.Done || r.Sent || r.Held != nil }
+
+// notify sends the notice of the emails by the notifiers with the policy,
+// and logs the outcome of each notifier with the attributes.
+//
+// The notifiers whose results have been done are skipped, and the results
+// are updated with the outcomes of the notifiers which are tried this time.
+// A notifier holding the notice, such as in the quiet hours, is not
+// a failure and is counted as done by the policy, but the held emails
+// must be sent by it later.
+//
+// It returns the error joining the failures of the notifiers
+// if the policy is not satisfied.
+func notify(ctx context.Context, policy NotifyPolicy, notifiers []notice.Notifier,
+	results []notifyResult, emails []email.Email, attrs ...any) (err error) {
+	if len(notifiers) == 0 {
+		return
+	}
+
+	controller := notice.ControllerFromContext(ctx)
+	logger := slog.With(attrs...).With("policy", policy.String())
+	send := func(i int) {
+		notifier := notifiers[i]
+		start := time.Now()
+		err := notifier.Notify(ctx, emails...)
+		name := notice.NameOf(notifier)
+		notifyDuration.Observe(time.Since(start).Seconds(), controller, name)
+
+		var held *notice.HeldError
+		switch {
+		case err == nil:
+			results[i].Sent = true
+			notifySuccesses.Inc(controller, name)
+			logger.Info("send new email notice", "notifier", notifier.String())
+		case errors.As(err, &held):
+			if held.Emails == nil {
+				held.Emails = emails
+			}
+			results[i].Held = held
+			logger.Info("the notice is held", "notifier", notifier.String(),
+				"emails", len(held.Emails), "until", held.Until)
+		default:
+			results[i].Err = fmt.Errorf("%s: %w", notifier.String(), err)
+			notifyFailures.Inc(controller, name)
+			logger.Error("fail to send notice", "notifier", notifier.String(), "err", err)
+		}
+	}
+
+	if policy.mode == "" || policy == PolicyFailover {
+		for i := range notifiers {
+			if results[i].Done {
+				return
+			}
+		}
+
+		errs := make([]error, 0, len(notifiers))
+		for i := range notifiers {
+			if send(i); results[i].done() {
+				return
+			}
+			errs = append(errs, results[i].Err)
+		}
+		return errors.Join(errs...)
+	}
+
+	var wg sync.WaitGroup
+	for i := range notifiers {
+		if results[i].Done {
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			send(i)
+		}(i)
+	}
+	wg.Wait()
+
+	var done int
+	errs := make([]error, 0, len(notifiers)+1)
+	for _, result := range results {
+		if result.done() {
+			done++
+		} else if result.Err != nil {
+			errs = append(errs, result.Err)
+		}
+	}
+
+	quorum := len(notifiers)
+	if policy.mode == "quorum" {
+		quorum = policy.quorum
+	}
+
+	if done < quorum {
+		errs = append(errs, fmt.Errorf("only %d of %d notifiers succeeded, but require %d",
+			done, len(notifiers), quorum))
+		err = errors.Join(errs...)
+	}
+	return
+}
@@ -0,0 +1,296 @@
+// Copyright 2023 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"github.com/xgfone/emailmanager/pkg/email"
+	"github.com/xgfone/emailmanager/pkg/notice"
+)
+
+var outboxSeq atomic.Uint64
+
+func newOutboxID() string {
+	return fmt.Sprintf("%d-%d", time.Now().UnixNano(), outboxSeq.Add(1))
+}
+
+// deliver sends the notice of the outbox entry by the notifiers which have
+// not sent it, records those sending it, and removes it from the outbox
+// only after the policy is satisfied.
+//
+// If all the failed notifiers fail permanently, see notice.IsRetryable,
+// the notice is dropped instead of being redelivered.
+//
+// If a notifier holds the emails, such as in the quiet hours, the held
+// emails are moved into a new entry only redelivered by the notifier
+// after the time it is held until, which is not a failure.
+//
+// It returns the notifiers which have sent the notice successfully.
+func deliver(ctx context.Context, config config, notifiers []notice.Notifier,
+	entry email.OutboxEntry) (sent []string, err error) {
+	policy := config.Policy
+	keys := notifierKeys(notifiers)
+	if entry.Notifier != "" {
+		index := slices.Index(keys, entry.Notifier)
+		if index < 0 {
+			slog.Warn("drop the pending notice of the removed notifier", "controller", config.Name,
+				"mailbox", entry.Mailbox, "id", entry.ID, "notifier", entry.Notifier)
+			removeOutboxEntry(config, entry)
+			return
+		}
+		notifiers, keys, policy = notifiers[index:index+1], keys[index:index+1], PolicyAll
+	}
+
+	results := make([]notifyResult, len(notifiers))
+	for i, key := range keys {
+		results[i].Done = slices.Contains(entry.Sent, key)
+	}
+
+	err = notify(ctx, policy, notifiers, results, entry.Emails,
+		"controller", config.Name, "mailbox", entry.Mailbox)
+
+	for i, result := range results {
+		switch {
+		case result.Sent:
+			sent = append(sent, notifiers[i].String())
+			entry.Sent = append(entry.Sent, keys[i])
+
+		case result.Held != nil:
+			held := email.OutboxEntry{
+				ID:       newOutboxID(),
+				Owner:    entry.Owner,
+				Mailbox:  entry.Mailbox,
+				Emails:   result.Held.Emails,
+				Created:  entry.Created,
+				Until:    result.Held.Until,
+				Notifier: keys[i],
+			}
+			if _err := config.Outbox.Put(held); _err != nil {
+				slog.Error("fail to save the held notice into outbox", "controller", config.Name,
+					"mailbox", entry.Mailbox, "id", held.ID, "notifier", keys[i], "err", _err)
+				continue
+			}
+			entry.Sent = append(entry.Sent, keys[i])
+		}
+	}
+
+	if err == nil {
+		removeOutboxEntry(config, entry)
+		return
+	}
+
+	if failedPermanently(results) {
+		slog.Error("drop the pending notice failing permanently", "controller", config.Name,
+			"mailbox", entry.Mailbox, "id", entry.ID, "emails", len(entry.Emails), "err", err)
+		removeOutboxEntry(config, entry)
+		return
+	}
+
+	entry.Attempts++
+	entry.Until = time.Time{}
+	if _err := config.Outbox.Put(entry); _err != nil {
+		slog.Error("fail to update the pending notice in outbox", "controller", config.Name,
+			"mailbox", entry.Mailbox, "id", entry.ID, "err", _err)
+	}
+	return
+}
+
+// failedPermanently reports whether all the failures of the notifiers
+// are permanent, such as the invalid token, which are not redelivered.
+func failedPermanently(results []notifyResult) bool {
+	var failed bool
+	for _, result := range results {
+		if result.Err != nil {
+			// The canceled notice, such as when stopping, is redelivered later.
+			if notice.IsRetryable(result.Err) || errors.Is(result.Err, context.Canceled) {
+				return false
+			}
+			failed = true
+		}
+	}
+	return failed
+}
+
+func removeOutboxEntry(config config, entry email.OutboxEntry) {
+	if err := config.Outbox.Remove(entry.ID); err != nil {
+		slog.Error("fail to remove the sent notice from outbox", "controller", config.Name,
+			"mailbox", entry.Mailbox, "id", entry.ID, "err", err)
+	}
+}
+
+const (
+	minRedeliverInterval = time.Minute
+	maxRedeliverInterval = time.Minute * 30
+)
+
+// runRedeliver redelivers the pending notices in the outbox periodically
+// until ctx is done or the controller is stopped.
+//
+// The interval starts from minRedeliverInterval, and is doubled
+// each time any notice fails to be redelivered, up to maxRedeliverInterval.
+func (c *Controller) runRedeliver(ctx context.Context, started time.Time) {
+	interval := minRedeliverInterval
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.stop:
+			return
+		case <-timer.C:
+		}
+
+		if c.redeliver(ctx, c.loadConfig(), started) {
+			interval = minRedeliverInterval
+		} else if interval *= 2; interval > maxRedeliverInterval {
+			interval = maxRedeliverInterval
+		}
+		timer.Reset(interval)
+	}
+}
+
+// redeliver redelivers the pending notices in the outbox, which are sent
+// by the notifiers of the mailbox they belong to, and reports whether
+// all of them have been sent successfully.
+//
+// Only the notices which have failed to be sent, have been held, or were
+// created before the controller started, are redelivered, because the others
+// are being delivered by the check. The notices of the same mailbox to be sent
+// by the same notifiers are merged and sent as a single digest, such as
+// the notices held in the quiet hours.
+// And the notices which exceed the max attempts or age are dropped.
+func (c *Controller) redeliver(ctx context.Context, config config, started time.Time) (ok bool) {
+	entries, err := config.Outbox.Pending(config.Name)
+	if err != nil {
+		slog.Error("fail to load the pending notices from outbox",
+			"controller", config.Name, "err", err)
+		return false
+	}
+
+	now := time.Now()
+	pendings := make([]email.OutboxEntry, 0, len(entries))
+	for _, entry := range entries {
+		switch {
+		case entry.Until.After(now):
+			continue
+		case entry.Attempts == 0 && entry.Until.IsZero() && !entry.Created.Before(started):
+			continue
+		}
+
+		// The held notice is aged since it is released.
+		since := entry.Created
+		if entry.Until.After(since) {
+			since = entry.Until
+		}
+
+		if entry.Attempts >= config.Redeliver.MaxAttempts || now.Sub(since) > config.Redeliver.MaxAge {
+			slog.Error("drop the pending notice exceeding the max attempts or age",
+				"controller", config.Name, "mailbox", entry.Mailbox, "id", entry.ID,
+				"emails", len(entry.Emails), "attempts", entry.Attempts, "created", entry.Created)
+			if err := config.Outbox.Remove(entry.ID); err != nil {
+				slog.Error("fail to remove the dropped notice from outbox", "controller", config.Name,
+					"mailbox", entry.Mailbox, "id", entry.ID, "err", err)
+			}
+			continue
+		}
+
+		index := slices.IndexFunc(pendings, func(e email.OutboxEntry) bool { return mergeable(e, entry) })
+		if index < 0 {
+			pendings = append(pendings, entry)
+		} else if merged, ok := mergeOutboxEntry(config, pendings[index], entry); ok {
+			pendings[index] = merged
+		}
+	}
+
+	ok = true
+	for _, entry := range pendings {
+		if ctx.Err() != nil || c.stopped() {
+			return
+		}
+
+		notifiers := config.Notifiers
+		for _, mailbox := range config.mailboxes() {
+			if mailbox.Name == entry.Mailbox && mailbox.Notifiers != nil {
+				notifiers = mailbox.Notifiers
+				break
+			}
+		}
+
+		slog.Info("redeliver the pending notice", "controller", config.Name,
+			"mailbox", entry.Mailbox, "id", entry.ID, "emails", len(entry.Emails),
+			"attempts", entry.Attempts, "created", entry.Created)
+		if err := redeliverEntry(ctx, config, notifiers, entry); err != nil {
+			ok = false
+		}
+	}
+	return
+}
+
+// mergeable reports whether both the entries are redelivered
+// by the same notifiers of the same mailbox.
+func mergeable(e1, e2 email.OutboxEntry) bool {
+	if e1.Mailbox != e2.Mailbox || e1.Notifier != e2.Notifier || len(e1.Sent) != len(e2.Sent) {
+		return false
+	}
+	for _, sent := range e1.Sent {
+		if !slices.Contains(e2.Sent, sent) {
+			return false
+		}
+	}
+	return true
+}
+
+// mergeOutboxEntry merges the entry into the merged entry in the outbox,
+// which keeps the created time of the merged and the most attempts.
+//
+// If failing, the entry is left in the outbox to be redelivered next time.
+func mergeOutboxEntry(config config, merged, entry email.OutboxEntry) (email.OutboxEntry, bool) {
+	merged.Emails = append(slices.Clip(merged.Emails), entry.Emails...)
+	merged.Attempts = max(merged.Attempts, entry.Attempts)
+	merged.Until = time.Time{}
+
+	if err := config.Outbox.Put(merged); err != nil {
+		slog.Error("fail to merge the pending notices in outbox", "controller", config.Name,
+			"mailbox", entry.Mailbox, "id", entry.ID, "into", merged.ID, "err", err)
+		return merged, false
+	}
+
+	if err := config.Outbox.Remove(entry.ID); err != nil {
+		slog.Error("fail to remove the merged notice from outbox", "controller", config.Name,
+			"mailbox", entry.Mailbox, "id", entry.ID, "into", merged.ID, "err", err)
+	}
+	return merged, true
+}
+
+func redeliverEntry(ctx context.Context, config config,
+	notifiers []notice.Notifier, entry email.OutboxEntry) (err error) {
+	ctx = notice.WithController(ctx, config.Name)
+	if config.Timeout > 0 {
+		var cancel func()
+		ctx, cancel = context.WithTimeout(ctx, config.Timeout)
+		defer cancel()
+	}
+	_, err = deliver(ctx, config, notifiers, entry)
+	return
+}
@@ -0,0 +1,208 @@
+// Copyright 2023 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/xgfone/emailmanager/pkg/email"
+	"github.com/xgfone/emailmanager/pkg/notice"
+)
+
+// testNotifier records the subjects of the emails it has sent,
+// and returns the error of the next call from errs if not empty.
+type testNotifier struct {
+	name string
+	errs []error
+	sent []string
+}
+
+func (n *testNotifier) notifier() notice.Notifier {
+	return notice.NewNotifier(n.name, func(ctx context.Context, emails ...notice.Email) (err error) {
+		if len(n.errs) > 0 {
+			err, n.errs = n.errs[0], n.errs[1:]
+		}
+		if err == nil {
+			for _, e := range emails {
+				n.sent = append(n.sent, e.Subject)
+			}
+		}
+		return
+	})
+}
+
+func testEmails(subjects ...string) []email.Email {
+	emails := make([]email.Email, len(subjects))
+	for i, subject := range subjects {
+		emails[i].Subject = subject
+	}
+	return emails
+}
+
+func newTestOutboxEntry(emails []email.Email) email.OutboxEntry {
+	return email.OutboxEntry{
+		ID:      newOutboxID(),
+		Owner:   "test",
+		Mailbox: email.Inbox,
+		Emails:  emails,
+		Created: time.Now(),
+	}
+}
+
+func TestDeliverOnlyUnsent(t *testing.T) {
+	n1 := &testNotifier{name: "n1"}
+	n2 := &testNotifier{name: "n2", errs: []error{errors.New("n2 error")}}
+	n3 := &testNotifier{name: "n3"}
+	notifiers := []notice.Notifier{n1.notifier(), n2.notifier(), n3.notifier()}
+
+	for _, policy := range []NotifyPolicy{PolicyAll, PolicyQuorum(3)} {
+		n1.sent, n2.sent, n3.sent = nil, nil, nil
+		n2.errs = []error{errors.New("n2 error")}
+
+		var c config
+		OutboxOption(email.NewMemoryOutbox())(&c)
+		NotifyPolicyOption(policy)(&c)
+		NameOption("test")(&c)
+		c.setDefaults()
+
+		entry := newTestOutboxEntry(testEmails("a", "b"))
+		if _, err := deliver(context.Background(), c, notifiers, entry); err == nil {
+			t.Fatalf("%s: expect an error, but got nil", policy)
+		}
+
+		entries, _ := c.Outbox.Pending("test")
+		if len(entries) != 1 {
+			t.Fatalf("%s: expect 1 pending entry, but got %d", policy, len(entries))
+		} else if entry = entries[0]; entry.Attempts != 1 || len(entry.Sent) != 2 {
+			t.Fatalf("%s: unexpected pending entry: attempts=%d, sent=%v", policy, entry.Attempts, entry.Sent)
+		}
+
+		sent, err := deliver(context.Background(), c, notifiers, entry)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", policy, err)
+		} else if len(sent) != 1 || sent[0] != "n2" {
+			t.Errorf("%s: expect only n2 redelivers the notice, but got %v", policy, sent)
+		}
+
+		if len(n1.sent) != 2 || len(n2.sent) != 2 || len(n3.sent) != 2 {
+			t.Errorf("%s: expect each notifier sends 2 emails once, but got n1=%v, n2=%v, n3=%v",
+				policy, n1.sent, n2.sent, n3.sent)
+		}
+		if entries, _ = c.Outbox.Pending("test"); len(entries) != 0 {
+			t.Errorf("%s: expect no pending entries, but got %d", policy, len(entries))
+		}
+	}
+}
+
+func TestDeliverHeld(t *testing.T) {
+	until := time.Now().Add(time.Hour)
+	n1 := &testNotifier{name: "n1", errs: []error{&notice.HeldError{Until: until, Emails: testEmails("b")}}}
+	n2 := &testNotifier{name: "n2", errs: []error{errors.New("n2 error")}}
+	notifiers := []notice.Notifier{n1.notifier(), n2.notifier()}
+
+	var c config
+	OutboxOption(email.NewMemoryOutbox())(&c)
+	NotifyPolicyOption(PolicyAll)(&c)
+	NameOption("test")(&c)
+	c.setDefaults()
+
+	// The failure of n2 is not hidden by n1 holding the emails.
+	entry := newTestOutboxEntry(testEmails("a", "b"))
+	if _, err := deliver(context.Background(), c, notifiers, entry); err == nil {
+		t.Fatal("expect an error, but got nil")
+	} else if errors.As(err, new(*notice.HeldError)) {
+		t.Errorf("unexpected held error: %v", err)
+	}
+
+	entries, _ := c.Outbox.Pending("test")
+	if len(entries) != 2 {
+		t.Fatalf("expect 2 pending entries, but got %d", len(entries))
+	}
+
+	var pending, held email.OutboxEntry
+	for _, entry := range entries {
+		if entry.Notifier == "" {
+			pending = entry
+		} else {
+			held = entry
+		}
+	}
+
+	if pending.Attempts != 1 || len(pending.Emails) != 2 || len(pending.Sent) != 1 || pending.Sent[0] != "n1" {
+		t.Errorf("unexpected pending entry: attempts=%d, emails=%d, sent=%v",
+			pending.Attempts, len(pending.Emails), pending.Sent)
+	}
+	if held.Notifier != "n1" || !held.Until.Equal(until) || held.Attempts != 0 ||
+		len(held.Emails) != 1 || held.Emails[0].Subject != "b" {
+		t.Errorf("unexpected held entry: notifier=%s, until=%s, attempts=%d, emails=%d",
+			held.Notifier, held.Until, held.Attempts, len(held.Emails))
+	}
+
+	// The pending notice is only redelivered by n2, and the held by n1.
+	if _, err := deliver(context.Background(), c, notifiers, pending); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := deliver(context.Background(), c, notifiers, held); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(n1.sent) != 1 || n1.sent[0] != "b" {
+		t.Errorf("expect n1 sends the held email b, but got %v", n1.sent)
+	}
+	if len(n2.sent) != 2 {
+		t.Errorf("expect n2 sends 2 emails, but got %v", n2.sent)
+	}
+	if entries, _ = c.Outbox.Pending("test"); len(entries) != 0 {
+		t.Errorf("expect no pending entries, but got %d", len(entries))
+	}
+}
+
+func TestDeliverPermanentError(t *testing.T) {
+	n1 := &testNotifier{name: "n1", errs: []error{notice.PermanentError(errors.New("invalid token"))}}
+	n2 := &testNotifier{name: "n2", errs: []error{context.Canceled}}
+
+	var c config
+	OutboxOption(email.NewMemoryOutbox())(&c)
+	NameOption("test")(&c)
+	c.setDefaults()
+
+	for _, n := range []*testNotifier{n1, n2} {
+		entry := newTestOutboxEntry(testEmails("a"))
+		if _, err := deliver(context.Background(), c, []notice.Notifier{n.notifier()}, entry); err == nil {
+			t.Errorf("%s: expect an error, but got nil", n.name)
+		}
+	}
+
+	// Only the notice failing permanently is dropped.
+	if entries, _ := c.Outbox.Pending("test"); len(entries) != 1 {
+		t.Errorf("expect 1 pending entry, but got %d", len(entries))
+	}
+}
+
+func TestNotifierKeys(t *testing.T) {
+	notifiers := []notice.Notifier{
+		(&testNotifier{name: "a"}).notifier(),
+		(&testNotifier{name: "b"}).notifier(),
+		(&testNotifier{name: "a"}).notifier(),
+	}
+
+	keys := notifierKeys(notifiers)
+	if len(keys) != 3 || keys[0] != "a" || keys[1] != "b" || keys[2] != "a#2" {
+		t.Errorf("unexpected keys %v", keys)
+	}
+}
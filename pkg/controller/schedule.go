@@ -0,0 +1,376 @@
+// Copyright 2023 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xgfone/emailmanager/pkg/daily"
+)
+
+// Schedule decides when to check the emails next time.
+type Schedule interface {
+	// Next returns the next time after now to check the emails.
+	Next(now time.Time) time.Time
+	String() string
+}
+
+// ScheduleOption returns an option about the schedule to check the emails,
+// which takes precedence over the interval.
+//
+// If not set, check the emails every interval.
+func ScheduleOption(schedule Schedule) Option {
+	return func(c *config) { c.Schedule = schedule }
+}
+
+// Every returns a schedule which checks the emails every interval.
+func Every(interval time.Duration) Schedule {
+	if interval <= 0 {
+		panic("Every: interval must be greater than 0")
+	}
+	return everySchedule(interval)
+}
+
+type everySchedule time.Duration
+
+func (s everySchedule) Next(now time.Time) time.Time { return now.Add(time.Duration(s)) }
+func (s everySchedule) String() string               { return "@every " + time.Duration(s).String() }
+
+// ParseSchedule parses the schedule in the location, which is one of
+//
+//   - the cron expression with 5 fields, "minute hour day-of-month month
+//     day-of-week", such as "*/5 9-18 * * Mon-Fri";
+//   - the descriptors, "@every DURATION", "@hourly", "@daily" or "@weekly";
+//   - the named schedule, which consists of the rules separated by commas,
+//     such as "every 1m during 09:00-19:00 Mon-Fri, every 30m otherwise".
+//
+// For the named schedule, each rule is "every DURATION during HH:MM-HH:MM
+// [DAYS]", and the first rule whose window contains the current time is used.
+// The last rule may be "every DURATION otherwise" as the default, which is
+// "every 15m otherwise" if missing. The window may cross midnight, such as
+// "22:00-06:00", and DAYS is a list of weekdays or their ranges, such as
+// "Mon-Fri", "Fri-Mon" or "Sat,Sun", which are the days when the window starts.
+//
+// If loc is nil, use time.Local.
+func ParseSchedule(spec string, loc *time.Location) (Schedule, error) {
+	if loc == nil {
+		loc = time.Local
+	}
+
+	spec = strings.TrimSpace(spec)
+	switch {
+	case spec == "":
+		return nil, fmt.Errorf("empty schedule")
+
+	case strings.HasPrefix(spec, "@every "):
+		interval, err := time.ParseDuration(strings.TrimSpace(spec[len("@every "):]))
+		if err != nil || interval <= 0 {
+			return nil, fmt.Errorf("invalid schedule '%s'", spec)
+		}
+		return Every(interval), nil
+
+	case spec == "@hourly":
+		return ParseCron("0 * * * *", loc)
+	case spec == "@daily", spec == "@midnight":
+		return ParseCron("0 0 * * *", loc)
+	case spec == "@weekly":
+		return ParseCron("0 0 * * 0", loc)
+
+	case strings.HasPrefix(strings.ToLower(spec), "every "):
+		return parseRuleSchedule(spec, loc)
+
+	default:
+		return ParseCron(spec, loc)
+	}
+}
+
+var (
+	monthNames = map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}
+
+	weekdayNames = map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}
+)
+
+// ParseCron parses the cron expression with 5 fields in the location,
+// "minute hour day-of-month month day-of-week", each of which supports
+// "*", "N", "N-M", "*/S", "N-M/S" and their lists separated by commas.
+// The month and day-of-week fields also support the names, such as "Jan"
+// and "Mon", and 7 is also Sunday for day-of-week.
+//
+// Like the classic cron, if both day-of-month and day-of-week are
+// restricted, the day matches if either of them matches.
+//
+// If loc is nil, use time.Local.
+func ParseCron(expr string, loc *time.Location) (Schedule, error) {
+	if loc == nil {
+		loc = time.Local
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid cron expression '%s': expect 5 fields, but got %d", expr, len(fields))
+	}
+
+	s := &cronSchedule{expr: strings.Join(fields, " "), loc: loc}
+	var err error
+	if s.minute, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("invalid minute of cron expression '%s': %w", expr, err)
+	}
+	if s.hour, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return nil, fmt.Errorf("invalid hour of cron expression '%s': %w", expr, err)
+	}
+	if s.dom, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return nil, fmt.Errorf("invalid day of month of cron expression '%s': %w", expr, err)
+	}
+	if s.month, err = parseCronField(fields[3], 1, 12, monthNames); err != nil {
+		return nil, fmt.Errorf("invalid month of cron expression '%s': %w", expr, err)
+	}
+	if s.dow, err = parseCronField(fields[4], 0, 7, weekdayNames); err != nil {
+		return nil, fmt.Errorf("invalid day of week of cron expression '%s': %w", expr, err)
+	}
+
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1 // 7 is also Sunday.
+	}
+	s.domStar = fields[2] == "*" || strings.HasPrefix(fields[2], "*/")
+	s.dowStar = fields[4] == "*" || strings.HasPrefix(fields[4], "*/")
+
+	// 2000~2004 contains all the days, including Feb 29.
+	if start := time.Date(2000, 1, 1, 0, 0, 0, 0, loc); !s.Next(start).Before(start.AddDate(4, 0, 0)) {
+		return nil, fmt.Errorf("invalid cron expression '%s': it never matches", expr)
+	}
+	return s, nil
+}
+
+func parseCronField(field string, min, max int, names map[string]int) (bits uint64, err error) {
+	parse := func(s string) (int, error) {
+		if v, ok := names[strings.ToLower(s)]; ok {
+			return v, nil
+		}
+
+		v, err := strconv.Atoi(s)
+		if err != nil || v < min || v > max {
+			return 0, fmt.Errorf("'%s' is not in [%d, %d]", s, min, max)
+		}
+		return v, nil
+	}
+
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if rng, s, ok := strings.Cut(part, "/"); ok {
+			if step, err = strconv.Atoi(s); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step '%s'", s)
+			}
+			part = rng
+		}
+
+		var start, end int
+		switch {
+		case part == "*":
+			start, end = min, max
+
+		case strings.Contains(part, "-"):
+			first, last, _ := strings.Cut(part, "-")
+			if start, err = parse(first); err != nil {
+				return
+			}
+			if end, err = parse(last); err != nil {
+				return
+			}
+			if start > end {
+				return 0, fmt.Errorf("invalid range '%s'", part)
+			}
+
+		default:
+			if start, err = parse(part); err != nil {
+				return
+			}
+			if end = start; step > 1 {
+				end = max
+			}
+		}
+
+		for i := start; i <= end; i += step {
+			bits |= 1 << uint(i)
+		}
+	}
+	return
+}
+
+type cronSchedule struct {
+	expr string
+	loc  *time.Location
+
+	minute, hour, dom, month, dow uint64
+	domStar, dowStar              bool
+}
+
+func (s *cronSchedule) String() string {
+	return fmt.Sprintf("%s (%s)", s.expr, s.loc.String())
+}
+
+func (s *cronSchedule) matchDay(t time.Time) bool {
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+func (s *cronSchedule) Next(now time.Time) time.Time {
+	t := now.In(s.loc).Truncate(time.Minute).Add(time.Minute)
+
+	// Give up if no time matches within 5 years, such as "0 0 30 2 *".
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = forward(t, time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, s.loc))
+			continue
+		}
+		if !s.matchDay(t) {
+			t = forward(t, time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, s.loc))
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = forward(t, time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, s.loc))
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return limit
+}
+
+// forward returns next if it is after t, or t plus a minute instead.
+//
+// time.Date normalizes the time skipped by the DST transition,
+// such as 02:00 on the day when 02:00 jumps to 03:00, to the time
+// before the transition, which must not move t backward.
+func forward(t, next time.Time) time.Time {
+	if next.After(t) {
+		return next
+	}
+	return t.Add(time.Minute)
+}
+
+type scheduleRule struct {
+	daily.Window
+	interval time.Duration
+}
+
+type ruleSchedule struct {
+	spec  string
+	loc   *time.Location
+	rules []scheduleRule
+	other time.Duration
+}
+
+func (s *ruleSchedule) String() string {
+	return fmt.Sprintf("%s (%s)", s.spec, s.loc.String())
+}
+
+func (s *ruleSchedule) interval(t time.Time) time.Duration {
+	for _, r := range s.rules {
+		if _, ok := r.Active(t); ok {
+			return r.interval
+		}
+	}
+	return s.other
+}
+
+// Next returns the time after the interval of the active rule,
+// or the next boundary of the windows if it is earlier, so that
+// the interval of the next window takes effect on time.
+func (s *ruleSchedule) Next(now time.Time) time.Time {
+	now = now.In(s.loc)
+	next := now.Add(s.interval(now))
+
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, s.loc)
+	for ; day.Before(next); day = day.AddDate(0, 0, 1) {
+		for _, r := range s.rules {
+			for _, minute := range [2]int{r.Start, r.End} {
+				// Not day.Add, which is shifted by the DST transition of the day.
+				boundary := time.Date(day.Year(), day.Month(), day.Day(), 0, minute, 0, 0, s.loc)
+				if boundary.After(now) && boundary.Before(next) {
+					next = boundary
+				}
+			}
+		}
+	}
+	return next
+}
+
+func parseRuleSchedule(spec string, loc *time.Location) (Schedule, error) {
+	// Merge the day lists separated by commas into their rules,
+	// such as "every 1m during 09:00-12:00 Sat,Sun".
+	var clauses []string
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if strings.HasPrefix(strings.ToLower(part), "every ") || len(clauses) == 0 {
+			clauses = append(clauses, part)
+		} else {
+			clauses[len(clauses)-1] += "," + part
+		}
+	}
+
+	s := &ruleSchedule{spec: spec, loc: loc, other: time.Minute * 15}
+	for i, clause := range clauses {
+		fields := strings.Fields(clause)
+		if len(fields) < 2 || strings.ToLower(fields[0]) != "every" {
+			return nil, fmt.Errorf("invalid schedule rule '%s'", clause)
+		}
+
+		interval, err := time.ParseDuration(fields[1])
+		if err != nil || interval <= 0 {
+			return nil, fmt.Errorf("invalid interval of schedule rule '%s'", clause)
+		}
+
+		switch {
+		case len(fields) == 3 && strings.ToLower(fields[2]) == "otherwise":
+			if i != len(clauses)-1 {
+				return nil, fmt.Errorf("schedule rule '%s' must be the last", clause)
+			}
+			s.other = interval
+
+		case (len(fields) == 4 || len(fields) == 5) && strings.ToLower(fields[2]) == "during":
+			rule := scheduleRule{interval: interval}
+			if rule.Window, err = daily.ParseWindow(fields[3]); err != nil {
+				return nil, fmt.Errorf("invalid window of schedule rule '%s': %w", clause, err)
+			}
+			if len(fields) == 5 {
+				if rule.Days, err = daily.ParseWeekdays(fields[4]); err != nil {
+					return nil, fmt.Errorf("invalid days of schedule rule '%s': %w", clause, err)
+				}
+			}
+			s.rules = append(s.rules, rule)
+
+		default:
+			return nil, fmt.Errorf("invalid schedule rule '%s'", clause)
+		}
+	}
+
+	return s, nil
+}
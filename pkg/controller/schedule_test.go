@@ -0,0 +1,167 @@
+// Copyright 2023 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controller
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func bitsOf(values ...int) (bits uint64) {
+	for _, v := range values {
+		bits |= 1 << uint(v)
+	}
+	return
+}
+
+func TestParseCronField(t *testing.T) {
+	tests := []struct {
+		field    string
+		min, max int
+		names    map[string]int
+		bits     uint64
+		fail     bool
+	}{
+		{field: "*", min: 0, max: 5, bits: bitsOf(0, 1, 2, 3, 4, 5)},
+		{field: "3", min: 0, max: 59, bits: bitsOf(3)},
+		{field: "1,3,5", min: 0, max: 59, bits: bitsOf(1, 3, 5)},
+		{field: "1-3", min: 0, max: 59, bits: bitsOf(1, 2, 3)},
+		{field: "*/15", min: 0, max: 59, bits: bitsOf(0, 15, 30, 45)},
+		{field: "10/20", min: 0, max: 59, bits: bitsOf(10, 30, 50)},
+		{field: "1-10/3", min: 0, max: 59, bits: bitsOf(1, 4, 7, 10)},
+		{field: "*/5", min: 1, max: 12, bits: bitsOf(1, 6, 11)},
+		{field: "Jan,mar-MAY", min: 1, max: 12, names: monthNames, bits: bitsOf(1, 3, 4, 5)},
+		{field: "mon-fri", min: 0, max: 7, names: weekdayNames, bits: bitsOf(1, 2, 3, 4, 5)},
+
+		{field: "5-1", min: 0, max: 59, fail: true},
+		{field: "60", min: 0, max: 59, fail: true},
+		{field: "0", min: 1, max: 31, fail: true},
+		{field: "*/0", min: 0, max: 59, fail: true},
+		{field: "1-3/x", min: 0, max: 59, fail: true},
+		{field: "abc", min: 0, max: 59, fail: true},
+		{field: "", min: 0, max: 59, fail: true},
+	}
+
+	for _, test := range tests {
+		bits, err := parseCronField(test.field, test.min, test.max, test.names)
+		if test.fail {
+			if err == nil {
+				t.Errorf("'%s': expect an error, but got nil", test.field)
+			}
+		} else if err != nil {
+			t.Errorf("'%s': unexpected error: %v", test.field, err)
+		} else if bits != test.bits {
+			t.Errorf("'%s': expect bits %b, but got %b", test.field, test.bits, bits)
+		}
+	}
+}
+
+func TestParseScheduleError(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"@every 0s",
+		"@every x",
+		"* * * *",
+		"0 0 30 2 *",
+		"every 1m",
+		"every 0m during 09:00-18:00",
+		"every 1m during 09:00-09:00",
+		"every 1m during 09:00-25:00",
+		"every 1m during 09:00-18:00 Xyz",
+		"every 1m otherwise, every 5m during 09:00-18:00",
+	} {
+		if _, err := ParseSchedule(spec, time.UTC); err == nil {
+			t.Errorf("'%s': expect an error, but got nil", spec)
+		}
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	newyork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	utc := func(year int, month time.Month, day, hour, min int) time.Time {
+		return time.Date(year, month, day, hour, min, 0, 0, time.UTC)
+	}
+	nyc := func(year int, month time.Month, day, hour, min int) time.Time {
+		return time.Date(year, month, day, hour, min, 0, 0, newyork)
+	}
+
+	tests := []struct {
+		spec string
+		loc  *time.Location
+		now  time.Time
+		next time.Time
+	}{
+		{"@every 90s", time.UTC, utc(2023, 1, 1, 0, 0), utc(2023, 1, 1, 0, 1).Add(30 * time.Second)},
+		{"@hourly", time.UTC, utc(2023, 1, 1, 0, 0), utc(2023, 1, 1, 1, 0)},
+		{"*/5 9-18 * * Mon-Fri", time.UTC, utc(2023, 6, 2, 18, 56), utc(2023, 6, 5, 9, 0)},
+
+		// Month boundaries.
+		{"0 0 1 * *", time.UTC, utc(2023, 1, 31, 10, 0), utc(2023, 2, 1, 0, 0)},
+		{"0 0 31 * *", time.UTC, utc(2023, 4, 1, 0, 0), utc(2023, 5, 31, 0, 0)},
+		{"0 0 29 2 *", time.UTC, utc(2023, 3, 1, 0, 0), utc(2024, 2, 29, 0, 0)},
+		{"59 23 * 12 *", time.UTC, utc(2023, 12, 31, 23, 59), utc(2024, 12, 1, 23, 59)},
+
+		// Either day of month or day of week matches if both are restricted.
+		{"0 9 1 * Mon", time.UTC, utc(2023, 5, 2, 0, 0), utc(2023, 5, 8, 9, 0)},
+		{"0 9 1 * 7", time.UTC, utc(2023, 5, 2, 0, 0), utc(2023, 5, 7, 9, 0)},
+
+		// DST: 2023-03-12 02:00 EST jumps to 03:00 EDT,
+		// and 2023-11-05 02:00 EDT falls back to 01:00 EST.
+		{"30 2 * * *", newyork, nyc(2023, 3, 11, 3, 0), nyc(2023, 3, 13, 2, 30)},
+		{"0 9 * * *", newyork, nyc(2023, 3, 11, 10, 0), utc(2023, 3, 12, 13, 0)},
+		{"0 * * * *", newyork, utc(2023, 11, 5, 5, 30), utc(2023, 11, 5, 6, 0)},
+		{"0 9 * * *", newyork, nyc(2023, 11, 4, 10, 0), utc(2023, 11, 5, 14, 0)},
+
+		// Named schedules.
+		{"every 1m during 09:00-19:00 Mon-Fri, every 30m otherwise", time.UTC,
+			utc(2023, 6, 5, 10, 0), utc(2023, 6, 5, 10, 1)},
+		{"every 1m during 09:00-19:00 Mon-Fri, every 30m otherwise", time.UTC,
+			utc(2023, 6, 5, 8, 45), utc(2023, 6, 5, 9, 0)},
+		{"every 1m during 09:00-19:00 Mon-Fri, every 30m otherwise", time.UTC,
+			utc(2023, 6, 3, 10, 0), utc(2023, 6, 3, 10, 30)},
+		{"every 5m during 22:00-06:00 Fri-Mon", time.UTC,
+			utc(2023, 6, 3, 1, 0), utc(2023, 6, 3, 1, 5)}, // Sat, started on Fri.
+		{"every 5m during 22:00-06:00 Fri-Mon", time.UTC,
+			utc(2023, 6, 6, 1, 0), utc(2023, 6, 6, 1, 5)}, // Tue, started on Mon.
+		{"every 5m during 22:00-06:00 Fri-Mon", time.UTC,
+			utc(2023, 6, 7, 1, 0), utc(2023, 6, 7, 1, 15)}, // Wed, started on Tue.
+		{"every 5m during 22:00-06:00 Fri-Mon", time.UTC,
+			utc(2023, 6, 6, 23, 0), utc(2023, 6, 6, 23, 15)}, // Tue
+		{"every 1m during 00:00-01:00, every 30m otherwise", time.UTC,
+			utc(2023, 1, 31, 23, 50), utc(2023, 2, 1, 0, 0)},
+		{"every 1m during 09:00-10:00, every 12h otherwise", newyork,
+			nyc(2023, 3, 12, 0, 0), utc(2023, 3, 12, 13, 0)},
+		{"every 1m during 09:00-10:00, every 12h otherwise", newyork,
+			nyc(2023, 11, 5, 0, 0), utc(2023, 11, 5, 14, 0)},
+	}
+
+	for _, test := range tests {
+		schedule, err := ParseSchedule(test.spec, test.loc)
+		if err != nil {
+			t.Errorf("'%s': unexpected error: %v", test.spec, err)
+			continue
+		}
+
+		if next := schedule.Next(test.now); !next.Equal(test.next) {
+			t.Errorf("'%s': expect the next time after %s is %s, but got %s",
+				test.spec, test.now, test.next.In(test.loc), next.In(test.loc))
+		}
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright 2023 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package daily provides the daily time windows on the weekdays,
+// which are shared by the check schedules and the quiet hours.
+package daily
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var weekdays = map[string]int{
+	"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+}
+
+// Window is a daily time window, which crosses midnight
+// if End is before Start, such as "22:00-06:00".
+type Window struct {
+	Start, End int   // The minutes of the day.
+	Days       uint8 // The bits of the weekdays when the window starts. 0 means all days.
+}
+
+// NewWindow returns a new window from start to end, which are "HH:MM".
+func NewWindow(start, end string) (w Window, err error) {
+	if w.Start, err = ParseClock(start); err != nil {
+		return
+	}
+	if w.End, err = ParseClock(end); err != nil {
+		return
+	}
+	if w.Start == w.End {
+		err = fmt.Errorf("empty window '%s-%s'", start, end)
+	}
+	return
+}
+
+// ParseWindow parses the window "HH:MM-HH:MM".
+func ParseWindow(window string) (Window, error) {
+	start, end, ok := strings.Cut(window, "-")
+	if !ok {
+		return Window{}, fmt.Errorf("'%s' is not HH:MM-HH:MM", window)
+	}
+	return NewWindow(start, end)
+}
+
+// ParseClock parses the time of the day "HH:MM" and returns its minutes.
+func ParseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("'%s' is not HH:MM", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// ParseWeekdays parses the list of the weekdays or their ranges separated
+// by commas, such as "Mon-Fri", "Sat,Sun" or "Fri-Mon", and returns their bits.
+//
+// The weekday is the case-insensitive name, such as "Mon", or the number
+// from 0 to 7, where both 0 and 7 are Sunday, and the range may wrap
+// around the week, such as "Fri-Mon".
+func ParseWeekdays(days string) (bits uint8, err error) {
+	for _, part := range strings.Split(days, ",") {
+		first, last, isRange := strings.Cut(strings.TrimSpace(part), "-")
+		start, ok1 := parseWeekday(first)
+		end, ok2 := parseWeekday(last)
+		if !isRange {
+			end, ok2 = start, ok1
+		}
+		if !ok1 || !ok2 {
+			return 0, fmt.Errorf("invalid weekday '%s'", part)
+		}
+
+		for i := start; ; i = (i + 1) % 7 {
+			bits |= 1 << uint(i)
+			if i == end {
+				break
+			}
+		}
+	}
+	return
+}
+
+func parseWeekday(s string) (int, bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if v, ok := weekdays[s]; ok {
+		return v, true
+	}
+	if v, err := strconv.Atoi(s); err == nil && v >= 0 && v <= 7 {
+		return v % 7, true
+	}
+	return 0, false
+}
+
+// Active reports whether the window contains t, and returns
+// the end of the window instance containing t.
+func (w Window) Active(t time.Time) (end time.Time, ok bool) {
+	minute := t.Hour()*60 + t.Minute()
+	year, month, day := t.Date()
+	switch {
+	case w.Start < w.End && minute >= w.Start && minute < w.End:
+	case w.Start > w.End && minute >= w.Start:
+		day++ // The window ends in the next day.
+	case w.Start > w.End && minute < w.End:
+		t = t.AddDate(0, 0, -1) // The window starts in the last day.
+	default:
+		return
+	}
+
+	if w.Days != 0 && w.Days&(1<<uint(t.Weekday())) == 0 {
+		return
+	}
+
+	// Not the midnight plus the minutes, which is shifted
+	// by the DST transition of the day.
+	return time.Date(year, month, day, 0, w.End, 0, 0, t.Location()), true
+}
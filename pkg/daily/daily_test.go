@@ -0,0 +1,117 @@
+// Copyright 2023 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package daily
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func bitsOf(days ...time.Weekday) (bits uint8) {
+	for _, day := range days {
+		bits |= 1 << uint(day)
+	}
+	return
+}
+
+func TestParseWeekdays(t *testing.T) {
+	tests := []struct {
+		days string
+		bits uint8
+		fail bool
+	}{
+		{days: "Mon-Fri", bits: bitsOf(time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday)},
+		{days: "sat, SUN", bits: bitsOf(time.Saturday, time.Sunday)},
+		{days: "Sun-Sat", bits: 0x7f},
+		{days: "7", bits: bitsOf(time.Sunday)},
+		{days: "1-3", bits: bitsOf(time.Monday, time.Tuesday, time.Wednesday)},
+		{days: "Fri-Mon", bits: bitsOf(time.Friday, time.Saturday, time.Sunday, time.Monday)},
+		{days: "Sat-Sun", bits: bitsOf(time.Saturday, time.Sunday)},
+		{days: "Fri-Mon,Wed", bits: bitsOf(time.Friday, time.Saturday, time.Sunday, time.Monday, time.Wednesday)},
+		{days: "Mon-Sun/2", fail: true},
+		{days: "Mon-Xyz", fail: true},
+		{days: "8", fail: true},
+		{days: "", fail: true},
+	}
+
+	for _, test := range tests {
+		bits, err := ParseWeekdays(test.days)
+		if test.fail {
+			if err == nil {
+				t.Errorf("'%s': expect an error, but got nil", test.days)
+			}
+		} else if err != nil {
+			t.Errorf("'%s': unexpected error: %v", test.days, err)
+		} else if bits != test.bits {
+			t.Errorf("'%s': expect bits %07b, but got %07b", test.days, test.bits, bits)
+		}
+	}
+}
+
+func TestParseWindow(t *testing.T) {
+	if w, err := ParseWindow("22:00-06:30"); err != nil {
+		t.Error(err)
+	} else if w.Start != 22*60 || w.End != 6*60+30 {
+		t.Errorf("unexpected window %+v", w)
+	}
+
+	for _, window := range []string{"", "09:00", "09:00-09:00", "09:00-24:00", "9-18"} {
+		if _, err := ParseWindow(window); err == nil {
+			t.Errorf("'%s': expect an error, but got nil", window)
+		}
+	}
+}
+
+func TestWindowActive(t *testing.T) {
+	newyork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	at := func(month time.Month, day, hour, min int) time.Time {
+		return time.Date(2023, month, day, hour, min, 0, 0, newyork)
+	}
+
+	office := Window{Start: 9 * 60, End: 18 * 60, Days: bitsOf(time.Monday, time.Friday)}
+	night := Window{Start: 22 * 60, End: 6 * 60, Days: bitsOf(time.Friday)}
+	early := Window{Start: 0, End: 3 * 60}
+
+	tests := []struct {
+		window Window
+		t      time.Time
+		end    time.Time
+		ok     bool
+	}{
+		{office, at(6, 5, 9, 0), at(6, 5, 18, 0), true},  // Mon
+		{office, at(6, 5, 18, 0), time.Time{}, false},    // Mon
+		{office, at(6, 6, 10, 0), time.Time{}, false},    // Tue
+		{night, at(6, 2, 23, 0), at(6, 3, 6, 0), true},   // Fri
+		{night, at(6, 3, 1, 0), at(6, 3, 6, 0), true},    // Sat, started on Fri.
+		{night, at(6, 3, 23, 0), time.Time{}, false},     // Sat
+		{night, at(6, 30, 23, 0), at(7, 1, 6, 0), true},  // Fri, ends in the next month.
+		{night, at(6, 2, 12, 0), time.Time{}, false},     // Fri
+		{early, at(3, 12, 1, 0), at(3, 12, 3, 0), true},  // The DST starts at 02:00.
+		{early, at(11, 5, 0, 30), at(11, 5, 3, 0), true}, // The DST ends at 02:00.
+		{early, at(3, 12, 4, 0), time.Time{}, false},
+	}
+
+	for i, test := range tests {
+		end, ok := test.window.Active(test.t)
+		if ok != test.ok || !end.Equal(test.end) {
+			t.Errorf("%d: expect %s/%v, but got %s/%v", i, test.end, test.ok, end, ok)
+		}
+	}
+}
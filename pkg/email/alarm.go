@@ -0,0 +1,335 @@
+// Copyright 2023 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package email
+
+import (
+	"bytes"
+	"container/list"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/xgfone/go-binder"
+)
+
+// Predefine the default limits of the alarm store.
+const (
+	DefaultAlarmMaxSize = 10000
+	DefaultAlarmTTL     = time.Hour * 24 * 7
+)
+
+// AlarmStore is used to record the alarmed emails.
+type AlarmStore interface {
+	// Mark marks the key as alarmed, and reports whether it is the first time,
+	// that's, the key has not been marked, or has expired or been evicted.
+	Mark(key string) (first bool, err error)
+}
+
+// AlarmKey returns the key of the email to mark it alarmed, which consists of
+// the account, mailbox, UIDVALIDITY, UID and Message-ID.
+func AlarmKey(e *Email) string {
+	return fmt.Sprintf("%s|%s|%d|%d|%s", e.Account(), e.Mailbox(),
+		e.UIDValidity(), e.UID(), e.MessageID)
+}
+
+// AlarmStoreBuilder is used to build an alarm store.
+type AlarmStoreBuilder func(configs map[string]interface{}) (AlarmStore, error)
+
+var alarmStoreBuilders = make(map[string]AlarmStoreBuilder, 4)
+
+// RegisterAlarmStoreBuilder registers the alarm store builder with the type.
+func RegisterAlarmStoreBuilder(_type string, build AlarmStoreBuilder) {
+	if _type == "" {
+		panic("RegisterAlarmStoreBuilder: alarm store type must not be empty")
+	}
+	if build == nil {
+		panic("RegisterAlarmStoreBuilder: alarm store builder must not be nil")
+	}
+	alarmStoreBuilders[_type] = build
+}
+
+// GetAlarmStoreBuilder returns the alarm store builder by the type.
+func GetAlarmStoreBuilder(_type string) AlarmStoreBuilder { return alarmStoreBuilders[_type] }
+
+// BuildAlarmStore builds an alarm store by the type and configs, and returns it.
+func BuildAlarmStore(_type string, configs map[string]interface{}) (AlarmStore, error) {
+	if build := GetAlarmStoreBuilder(_type); build != nil {
+		return build(configs)
+	}
+	return nil, fmt.Errorf("no alarm store builder typed '%s'", _type)
+}
+
+func init() {
+	RegisterAlarmStoreBuilder("memory", func(configs map[string]interface{}) (AlarmStore, error) {
+		var config struct {
+			MaxSize int
+			TTL     time.Duration `json:"Ttl"`
+		}
+		if err := binder.BindStructToMap(&config, "json", configs); err != nil {
+			return nil, err
+		}
+		return NewMemoryAlarmStore(config.MaxSize, config.TTL), nil
+	})
+
+	RegisterAlarmStoreBuilder("file", func(configs map[string]interface{}) (AlarmStore, error) {
+		var config struct {
+			Path    string `validate:"required"`
+			MaxSize int
+			TTL     time.Duration `json:"Ttl"`
+		}
+		if err := binder.BindStructToMap(&config, "json", configs); err != nil {
+			return nil, err
+		}
+		if config.Path == "" {
+			return nil, fmt.Errorf("missing the path of the alarm store file")
+		}
+		return NewFileAlarmStore(config.Path, config.MaxSize, config.TTL)
+	})
+}
+
+type alarmItem struct {
+	Key  string
+	Time time.Time
+}
+
+// alarmCache is a bounded set of the keys in the order of the marked time,
+// which evicts the expired keys, or the oldest keys if exceeding maxsize.
+type alarmCache struct {
+	maxsize int
+	ttl     time.Duration
+	order   *list.List
+	items   map[string]*list.Element
+}
+
+func newAlarmCache(maxsize int, ttl time.Duration) *alarmCache {
+	if maxsize <= 0 {
+		maxsize = DefaultAlarmMaxSize
+	}
+	if ttl <= 0 {
+		ttl = DefaultAlarmTTL
+	}
+	return &alarmCache{
+		maxsize: maxsize,
+		ttl:     ttl,
+		order:   list.New(),
+		items:   make(map[string]*list.Element, 256),
+	}
+}
+
+func (c *alarmCache) mark(key string, now time.Time) bool {
+	c.evict(now)
+	if _, ok := c.items[key]; ok {
+		return false
+	}
+
+	c.items[key] = c.order.PushBack(alarmItem{Key: key, Time: now})
+	for c.order.Len() > c.maxsize {
+		c.remove(c.order.Front())
+	}
+	return true
+}
+
+func (c *alarmCache) evict(now time.Time) {
+	for e := c.order.Front(); e != nil; e = c.order.Front() {
+		if now.Sub(e.Value.(alarmItem).Time) < c.ttl {
+			break
+		}
+		c.remove(e)
+	}
+}
+
+func (c *alarmCache) remove(e *list.Element) {
+	delete(c.items, e.Value.(alarmItem).Key)
+	c.order.Remove(e)
+}
+
+func (c *alarmCache) list() []alarmItem {
+	items := make([]alarmItem, 0, c.order.Len())
+	for e := c.order.Front(); e != nil; e = e.Next() {
+		items = append(items, e.Value.(alarmItem))
+	}
+	return items
+}
+
+// NewMemoryAlarmStore returns a new alarm store based on the memory,
+// which keeps at most maxsize keys and expires each key after ttl.
+//
+// If maxsize or ttl is equal to 0, use DefaultAlarmMaxSize or DefaultAlarmTTL.
+func NewMemoryAlarmStore(maxsize int, ttl time.Duration) AlarmStore {
+	return &memoryAlarmStore{cache: newAlarmCache(maxsize, ttl)}
+}
+
+type memoryAlarmStore struct {
+	lock  sync.Mutex
+	cache *alarmCache
+}
+
+func (s *memoryAlarmStore) Mark(key string) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.cache.mark(key, time.Now()), nil
+}
+
+var (
+	fileAlarmLock   sync.Mutex
+	fileAlarmStores = make(map[string]AlarmStore, 2)
+)
+
+// sharedAlarmStore returns the alarm store shared by the file path,
+// which is created by new for the first time.
+func sharedAlarmStore(path string, new func(abspath string) (AlarmStore, error)) (AlarmStore, error) {
+	abspath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	fileAlarmLock.Lock()
+	defer fileAlarmLock.Unlock()
+	if s, ok := fileAlarmStores[abspath]; ok {
+		return s, nil
+	}
+
+	s, err := new(abspath)
+	if err != nil {
+		return nil, err
+	}
+	fileAlarmStores[abspath] = s
+	return s, nil
+}
+
+// NewFileAlarmStore returns a new alarm store based on the file,
+// which is the same as NewMemoryAlarmStore, but loads the keys from
+// the file if it exists, and appends the marked key into the file.
+// The file is compacted when the expired or evicted keys are too many.
+//
+// The store is shared by the same file path, and maxsize and ttl
+// only take effect when the store is created for the first time.
+func NewFileAlarmStore(path string, maxsize int, ttl time.Duration) (AlarmStore, error) {
+	if path == "" {
+		panic("NewFileAlarmStore: path must not be empty")
+	}
+
+	return sharedAlarmStore(path, func(abspath string) (AlarmStore, error) {
+		s := &fileAlarmStore{filepath: abspath, cache: newAlarmCache(maxsize, ttl)}
+		if err := s.load(); err != nil {
+			return nil, err
+		}
+		if err := s.compact(); err != nil {
+			return nil, err
+		}
+		return s, nil
+	})
+}
+
+// minAlarmCompactLines is the minimum number of the lines
+// in the alarm store file to compact it.
+const minAlarmCompactLines = 1024
+
+type fileAlarmStore struct {
+	filepath string
+
+	lock  sync.Mutex
+	cache *alarmCache
+	file  *os.File // The file opened to append the marked keys.
+	lines int      // The number of the lines in the file.
+}
+
+// load loads the marked keys from the file, each line of which is
+// a json object of alarmItem, and ignores the lines broken by the crash.
+func (s *fileAlarmStore) load() error {
+	data, err := os.ReadFile(s.filepath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	lines := bytes.Split(bytes.TrimSpace(data), []byte{'\n'})
+	for _, line := range lines {
+		if len(line) == 0 {
+			continue
+		}
+
+		var item alarmItem
+		if json.Unmarshal(line, &item) != nil {
+			continue
+		}
+
+		if now.Sub(item.Time) < s.cache.ttl {
+			s.cache.mark(item.Key, item.Time)
+		}
+	}
+	return nil
+}
+
+// compact rewrites the file with the unexpired keys,
+// and reopens it to append the marked keys.
+func (s *fileAlarmStore) compact() (err error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	items := s.cache.list()
+	for _, item := range items {
+		if err = encoder.Encode(item); err != nil {
+			return
+		}
+	}
+
+	if err = writeFileAtomically(s.filepath, buf.Bytes()); err != nil {
+		return
+	}
+
+	if s.file != nil {
+		s.file.Close()
+		s.file = nil
+	}
+
+	file, err := os.OpenFile(s.filepath, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return
+	}
+	s.file, s.lines = file, len(items)
+	return
+}
+
+func (s *fileAlarmStore) Mark(key string) (first bool, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := time.Now()
+	if first = s.cache.mark(key, now); !first {
+		return
+	}
+
+	if s.lines >= minAlarmCompactLines && s.lines >= 2*s.cache.order.Len() {
+		err = s.compact()
+		return
+	}
+
+	data, err := json.Marshal(alarmItem{Key: key, Time: now})
+	if err != nil {
+		return
+	}
+
+	if _, err = s.file.Write(append(data, '\n')); err == nil {
+		s.lines++
+	}
+	return
+}
@@ -0,0 +1,158 @@
+// Copyright 2023 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package email
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/xgfone/go-binder"
+	bolt "go.etcd.io/bbolt"
+)
+
+func init() {
+	RegisterAlarmStoreBuilder("bolt", func(configs map[string]interface{}) (AlarmStore, error) {
+		var config struct {
+			Path    string `validate:"required"`
+			MaxSize int
+			TTL     time.Duration `json:"Ttl"`
+		}
+		if err := binder.BindStructToMap(&config, "json", configs); err != nil {
+			return nil, err
+		}
+		if config.Path == "" {
+			return nil, fmt.Errorf("missing the path of the alarm store bolt db")
+		}
+		return NewBoltAlarmStore(config.Path, config.MaxSize, config.TTL)
+	})
+}
+
+var (
+	boltAlarmKeys  = []byte("keys")  // key -> marked time
+	boltAlarmOrder = []byte("order") // marked time + key -> nil
+	boltAlarmMeta  = []byte("meta")  // "count" -> the number of the keys
+	boltAlarmCount = []byte("count")
+)
+
+// NewBoltAlarmStore returns a new alarm store based on the embedded
+// bolt db, which is the same as NewMemoryAlarmStore, but stores the keys
+// in the db file, and only the changed keys are written each time marking.
+//
+// The store is shared by the same file path, and maxsize and ttl
+// only take effect when the store is created for the first time.
+func NewBoltAlarmStore(path string, maxsize int, ttl time.Duration) (AlarmStore, error) {
+	if path == "" {
+		panic("NewBoltAlarmStore: path must not be empty")
+	}
+
+	return sharedAlarmStore(path, func(abspath string) (AlarmStore, error) {
+		db, err := bolt.Open(abspath, 0600, &bolt.Options{Timeout: time.Second * 5})
+		if err != nil {
+			return nil, err
+		}
+
+		err = db.Update(func(tx *bolt.Tx) (err error) {
+			for _, name := range [][]byte{boltAlarmKeys, boltAlarmOrder, boltAlarmMeta} {
+				if _, err = tx.CreateBucketIfNotExists(name); err != nil {
+					return
+				}
+			}
+			return
+		})
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+
+		cache := newAlarmCache(maxsize, ttl)
+		return &boltAlarmStore{db: db, maxsize: cache.maxsize, ttl: cache.ttl}, nil
+	})
+}
+
+type boltAlarmStore struct {
+	db      *bolt.DB
+	maxsize int
+	ttl     time.Duration
+}
+
+func (s *boltAlarmStore) Mark(key string) (first bool, err error) {
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		first = false
+		keys := tx.Bucket(boltAlarmKeys)
+		order := tx.Bucket(boltAlarmOrder)
+		meta := tx.Bucket(boltAlarmMeta)
+
+		count := decodeUint64(meta.Get(boltAlarmCount))
+		now := time.Now()
+
+		// Evict the expired keys.
+		cursor := order.Cursor()
+		for k, _ := cursor.First(); k != nil; k, _ = cursor.First() {
+			if now.Sub(time.Unix(0, int64(decodeUint64(k[:8])))) < s.ttl {
+				break
+			}
+			if err := s.remove(keys, order, k); err != nil {
+				return err
+			}
+			count--
+		}
+
+		if keys.Get([]byte(key)) != nil {
+			return meta.Put(boltAlarmCount, encodeUint64(count))
+		}
+
+		first = true
+		marked := encodeUint64(uint64(now.UnixNano()))
+		if err := keys.Put([]byte(key), marked); err != nil {
+			return err
+		}
+		if err := order.Put(append(marked, key...), nil); err != nil {
+			return err
+		}
+
+		// Evict the oldest keys if exceeding maxsize.
+		for count++; count > uint64(s.maxsize); count-- {
+			k, _ := cursor.First()
+			if k == nil {
+				break
+			}
+			if err := s.remove(keys, order, k); err != nil {
+				return err
+			}
+		}
+
+		return meta.Put(boltAlarmCount, encodeUint64(count))
+	})
+	return
+}
+
+func (s *boltAlarmStore) remove(keys, order *bolt.Bucket, orderkey []byte) error {
+	if err := keys.Delete(orderkey[8:]); err != nil {
+		return err
+	}
+	return order.Delete(orderkey)
+}
+
+func encodeUint64(v uint64) []byte {
+	return binary.BigEndian.AppendUint64(make([]byte, 0, 8), v)
+}
+
+func decodeUint64(b []byte) uint64 {
+	if len(b) < 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(b)
+}
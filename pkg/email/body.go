@@ -0,0 +1,151 @@
+// Copyright 2023 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package email
+
+import (
+	"bufio"
+	"errors"
+	"html"
+	"io"
+	"mime"
+	"net/textproto"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/emersion/go-message"
+	"github.com/emersion/go-message/charset"
+	"github.com/emersion/go-message/mail"
+)
+
+// Attachment represents the metadata of an email attachment.
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Size        int64 // The decoded size in bytes.
+}
+
+// Body represents the decoded body of an email message.
+type Body struct {
+	// Text is the plain-text body. If the message has no text/plain part,
+	// it is converted from the html body instead.
+	Text string
+	HTML string
+
+	Attachments []Attachment
+}
+
+// Preview returns the first at most n characters of the plain-text body,
+// whose consecutive whitespaces are collapsed into one space.
+func (b Body) Preview(n int) string {
+	text := strings.Join(strings.Fields(b.Text), " ")
+	if n <= 0 || utf8.RuneCountInString(text) <= n {
+		return text
+	}
+	return string([]rune(text)[:n]) + "..."
+}
+
+var headerDecoder = &mime.WordDecoder{CharsetReader: charset.Reader}
+
+// parseHeader parses the header of the RFC 822 message,
+// and decodes the encoded-words of the values.
+func parseHeader(r io.Reader) (textproto.MIMEHeader, error) {
+	header, err := textproto.NewReader(bufio.NewReader(r)).ReadMIMEHeader()
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	for _, values := range header {
+		for i, value := range values {
+			if decoded, err := headerDecoder.DecodeHeader(value); err == nil {
+				values[i] = decoded
+			}
+		}
+	}
+	return header, nil
+}
+
+// parseBody parses the RFC 822 message and returns its decoded body.
+func parseBody(r io.Reader) (body Body, err error) {
+	mr, err := mail.CreateReader(r)
+	if err != nil && !message.IsUnknownCharset(err) {
+		return
+	}
+	defer mr.Close()
+
+	var text, htmltext strings.Builder
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil && !message.IsUnknownCharset(err) && !message.IsUnknownEncoding(err) {
+			return body, err
+		}
+
+		switch header := part.Header.(type) {
+		case *mail.InlineHeader:
+			ctype, _, _ := header.ContentType()
+			switch ctype {
+			case "text/plain":
+				_, err = io.Copy(&text, part.Body)
+			case "text/html":
+				_, err = io.Copy(&htmltext, part.Body)
+			default:
+				_, err = io.Copy(io.Discard, part.Body)
+			}
+
+		case *mail.AttachmentHeader:
+			var attachment Attachment
+			attachment.Filename, _ = header.Filename()
+			attachment.ContentType, _, _ = header.ContentType()
+			attachment.Size, err = io.Copy(io.Discard, part.Body)
+			body.Attachments = append(body.Attachments, attachment)
+		}
+
+		if err != nil {
+			return body, err
+		}
+	}
+
+	body.HTML = htmltext.String()
+	if body.Text = text.String(); body.Text == "" && body.HTML != "" {
+		body.Text = htmlToText(body.HTML)
+	}
+	return
+}
+
+var (
+	htmlIgnoredRE = regexp.MustCompile(`(?is)<(script|style|head)\b.*?</(script|style|head)>`)
+	htmlNewlineRE = regexp.MustCompile(`(?i)<(br|/p|/div|/tr|/h[1-6]|/li|/table)\b[^>]*>`)
+	htmlTagRE     = regexp.MustCompile(`(?s)<[^>]*>`)
+	blankLinesRE  = regexp.MustCompile(`\n\s*\n\s*`)
+)
+
+// htmlToText converts the html to the plain text roughly.
+func htmlToText(s string) string {
+	s = htmlIgnoredRE.ReplaceAllString(s, "")
+	s = htmlNewlineRE.ReplaceAllString(s, "\n")
+	s = htmlTagRE.ReplaceAllString(s, "")
+	s = html.UnescapeString(s)
+
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		lines[i] = strings.Join(strings.Fields(line), " ")
+	}
+
+	s = strings.Join(lines, "\n")
+	s = blankLinesRE.ReplaceAllString(s, "\n\n")
+	return strings.TrimSpace(s)
+}
@@ -0,0 +1,238 @@
+// Copyright 2023 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package email
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-imap/commands"
+)
+
+// The server may log out the idle client after 30 minutes of inactivity
+// by RFC 2177, so re-issue IDLE before that.
+const idleTimeout = 25 * time.Minute
+
+// ErrAuthFailed is returned, as the wrapped error, when the mail server
+// rejects to log in, such as the wrong username or password.
+var ErrAuthFailed = errors.New("authentication failed")
+
+// Conn is an authenticated connection to the mail server.
+type Conn struct {
+	client  *client.Client
+	account string
+	changed chan struct{}
+}
+
+// Dial connects to the mail server and logs in with the username and password.
+//
+// If tlsconfig is nil, connect to the server without TLS.
+func Dial(addr, username, password string, tlsconfig *tls.Config) (*Conn, error) {
+	if addr == "" {
+		panic("mail server address must not be empty")
+	}
+	if username == "" {
+		panic("email username must not be empty")
+	}
+	if password == "" {
+		panic("email password must not be empty")
+	}
+
+	imapClient, err := dial(addr, username, password, tlsconfig)
+	if err != nil {
+		return nil, err
+	}
+
+	updates := make(chan client.Update, 16)
+	c := &Conn{
+		client:  imapClient,
+		account: Account(addr, username),
+		changed: make(chan struct{}, 1),
+	}
+
+	imapClient.Updates = updates
+	go c.watch(updates)
+	return c, nil
+}
+
+func dial(addr, username, password string, tlsconfig *tls.Config) (imapClient *client.Client, err error) {
+	if tlsconfig != nil {
+		imapClient, err = client.DialTLS(addr, tlsconfig)
+	} else {
+		imapClient, err = client.Dial(addr)
+	}
+	if err != nil {
+		return
+	}
+
+	if err = login(imapClient, username, password); err != nil {
+		imapClient.Terminate()
+		imapClient = nil
+	}
+	return
+}
+
+// login is the same as client.Login, but returns ErrAuthFailed only if
+// the server rejects the credentials by the tagged NO response,
+// not for the BAD response or the network errors.
+func login(imapClient *client.Client, username, password string) error {
+	cmd := &commands.Login{Username: username, Password: password}
+	status, err := imapClient.Execute(cmd, nil)
+	if err != nil {
+		return err
+	}
+	if err = status.Err(); err != nil {
+		if isAuthFailed(status) {
+			err = fmt.Errorf("%w: %w", ErrAuthFailed, err)
+		}
+		return err
+	}
+
+	// The capabilities change after logging in, so fetch them again.
+	imapClient.SetState(imap.AuthenticatedState, nil)
+	_, err = imapClient.Capability()
+	return err
+}
+
+// isAuthFailed reports whether the status response rejects the credentials,
+// that's, NO without the response code or with the one by RFC 5530
+// about the authentication, but not such as [UNAVAILABLE].
+func isAuthFailed(status *imap.StatusResp) bool {
+	if status.Type != imap.StatusRespNo {
+		return false
+	}
+
+	switch status.Code {
+	case "", "AUTHENTICATIONFAILED", "AUTHORIZATIONFAILED", "EXPIRED":
+		return true
+	default:
+		return false
+	}
+}
+
+// watch consumes the unilateral updates from the server to avoid blocking
+// the client, and only records whether the new messages have arrived.
+//
+// The server reports EXISTS not only for the new messages but also when
+// selecting the mailbox, and FETCH and EXPUNGE for the changes made by
+// ourselves, such as marking the emails as read or moving them. So only
+// the increase of the number of the messages in EXISTS is a change,
+// which is compared with the last known number of the same mailbox
+// counting the expunged messages.
+func (c *Conn) watch(updates <-chan client.Update) {
+	var selected string
+	messages := make(map[string]uint32, 4)
+	for {
+		select {
+		case <-c.client.LoggedOut():
+			return
+
+		case update := <-updates:
+			switch update := update.(type) {
+			case *client.MailboxUpdate: // EXISTS or RECENT
+				name, num := update.Mailbox.Name, update.Mailbox.Messages
+				if last, ok := messages[name]; ok && num > last {
+					select {
+					case c.changed <- struct{}{}:
+					default:
+					}
+				}
+				selected, messages[name] = name, num
+
+			case *client.ExpungeUpdate:
+				if messages[selected] > 0 {
+					messages[selected]--
+				}
+			}
+		}
+	}
+}
+
+// Close logs out and closes the connection.
+func (c *Conn) Close() error {
+	defer c.client.Terminate()
+	return c.client.Logout()
+}
+
+// Closed reports whether the connection has been closed.
+func (c *Conn) Closed() bool {
+	select {
+	case <-c.client.LoggedOut():
+		return true
+	default:
+		return false
+	}
+}
+
+// SupportIdle reports whether the server supports the IDLE extension.
+func (c *Conn) SupportIdle() (bool, error) {
+	return c.client.Support("IDLE")
+}
+
+// FetchNewEmails is the same as the function FetchNewEmails,
+// but uses the current connection.
+//
+// If body is true, fetch and decode the bodies of the emails as well.
+func (c *Conn) FetchNewEmails(ctx context.Context, mailbox string, body bool,
+	maxnum uint32, states StateStore, chains ...Handler) (emails []Email, goon bool, err error) {
+	if states == nil {
+		panic("Conn.FetchNewEmails: states must not be nil")
+	}
+	if mailbox == "" {
+		mailbox = Inbox
+	}
+	return fetchMailboxEmails(ctx, c.client, c.account, mailbox, body, maxnum, states, chains)
+}
+
+// Idle selects the mailbox and waits by IDLE until the server reports
+// that the new messages have arrived by EXISTS, or ctx is done.
+//
+// If the server does not support IDLE, it falls back to polling by NOOP.
+func (c *Conn) Idle(ctx context.Context, mailbox string) (err error) {
+	if mailbox == "" {
+		mailbox = Inbox
+	}
+
+	if status := c.client.Mailbox(); status == nil || status.Name != mailbox {
+		if _, err = c.client.Select(mailbox, false); err != nil {
+			return
+		}
+	}
+
+	stop := make(chan struct{})
+	done := make(chan error, 1)
+	go func() { done <- c.client.Idle(stop, &client.IdleOptions{LogoutTimeout: idleTimeout}) }()
+
+	select {
+	case <-ctx.Done():
+		close(stop)
+		if err = <-done; err == nil {
+			err = ctx.Err()
+		}
+
+	case <-c.changed:
+		close(stop)
+		err = <-done
+
+	case err = <-done:
+	}
+
+	return
+}
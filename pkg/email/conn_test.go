@@ -0,0 +1,86 @@
+// Copyright 2023 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package email
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend/memory"
+	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-imap/server"
+)
+
+func TestConnIdleWakeup(t *testing.T) {
+	imapServer := server.New(memory.New())
+	imapServer.AllowInsecureAuth = true
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go imapServer.Serve(listener)
+	defer imapServer.Close()
+
+	conn, err := Dial(listener.Addr().String(), "username", "password", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// The EXISTS by selecting the mailbox does not wake up IDLE.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	err = conn.Idle(ctx, Inbox)
+	cancel()
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expect not to wake up IDLE by selecting, but got %v", err)
+	}
+
+	exists := func(messages uint32) client.Update {
+		status := imap.NewMailboxStatus(Inbox, nil)
+		status.Messages = messages
+		return &client.MailboxUpdate{Mailbox: status}
+	}
+
+	// The default INBOX of the memory backend has 1 message.
+	tests := []struct {
+		name   string
+		update client.Update
+		wakeup bool
+	}{
+		{"FETCH", &client.MessageUpdate{Message: imap.NewMessage(1, nil)}, false},
+		{"EXPUNGE", &client.ExpungeUpdate{SeqNum: 1}, false},
+		{"EXISTS 1 after EXPUNGE", exists(1), true},
+		{"RECENT", exists(1), false},
+		{"EXISTS 2", exists(2), true},
+	}
+
+	for _, test := range tests {
+		conn.client.Updates <- test.update
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+		err := conn.Idle(ctx, Inbox)
+		cancel()
+
+		if test.wakeup && err != nil {
+			t.Errorf("'%s': expect to wake up IDLE, but got %v", test.name, err)
+		} else if !test.wakeup && !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("'%s': expect not to wake up IDLE, but got %v", test.name, err)
+		}
+	}
+}
@@ -0,0 +1,681 @@
+// Copyright 2023 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package email
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Matcher is used to check whether an email is matched.
+type Matcher func(*Email) bool
+
+// SyntaxError represents a syntax error of the matcher expression.
+type SyntaxError struct {
+	Line   int
+	Column int
+	Msg    string
+}
+
+// Error implements the interface error.
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("syntax error at line %d, column %d: %s", e.Line, e.Column, e.Msg)
+}
+
+// CompileMatcher compiles the boolean expression to a matcher.
+//
+// The expression consists of the comparisons, which are combined by
+// "and" (or "&&"), "or" (or "||"), "not" (or "!") and the parentheses.
+// A comparison is "FIELD OPERATOR VALUE", and the fields are
+//
+//	from, sender, to, cc      // The addresses, such as "user@example.com".
+//	subject, mailbox, body    // The body is empty if not fetched.
+//	flags, attachments        // The flags and filenames of the attachments.
+//	header("Name")            // The values of the header named Name.
+//	date, sent, received      // The time, such as "now - 1h", "2006-01-02".
+//	size                      // The size in bytes, such as 1024, 10KB, 1MB.
+//	read                      // The boolean field without the operator.
+//
+// The operators of the string fields are "==", "!=", "=~" (regexp),
+// "!~", "contains" and "glob", and those of the time and number fields
+// are "==", "!=", "<", "<=", ">" and ">=". For the multi-value fields,
+// such as from and flags, it is matched if any value is matched,
+// and the negative operators, "!=" and "!~", are matched if none matches.
+//
+// The string value is quoted by the double quotes with the escapes,
+// or the single quotes without the escapes, which is suitable to regexp.
+//
+// Example:
+//
+//	subject =~ 'ERROR|CRITICAL' and not sender glob "*@noreply.example.com"
+//	(from contains "zabbix" or header("X-Priority") == "1") and received > now - 1h
+func CompileMatcher(expr string) (Matcher, error) {
+	tokens, err := tokenize(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if token := p.peek(); token.kind != tokenEOF {
+		return nil, p.errorf(token, "unexpected %s", token)
+	}
+
+	return node.match, nil
+}
+
+type tokenKind uint8
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenNumber
+	tokenOperator
+)
+
+type token struct {
+	kind   tokenKind
+	text   string
+	line   int
+	column int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokenEOF:
+		return "end of expression"
+	case tokenString:
+		return strconv.Quote(t.text)
+	default:
+		return fmt.Sprintf("'%s'", t.text)
+	}
+}
+
+func (t token) is(kind tokenKind, texts ...string) bool {
+	if t.kind != kind {
+		return false
+	}
+	for _, text := range texts {
+		if strings.EqualFold(t.text, text) {
+			return true
+		}
+	}
+	return len(texts) == 0
+}
+
+var operators = []string{"==", "!=", "=~", "!~", "<=", ">=", "&&", "||", "<", ">", "!", "(", ")", "+", "-"}
+
+func tokenize(expr string) (tokens []token, err error) {
+	line, column := 1, 1
+	runes := []rune(expr)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		start := token{line: line, column: column}
+
+		switch {
+		case r == '\n':
+			i++
+			line, column = line+1, 1
+			continue
+
+		case unicode.IsSpace(r):
+			i++
+			column++
+			continue
+
+		case r == '"' || r == '\'':
+			j := i + 1
+			for ; j < len(runes) && runes[j] != r && runes[j] != '\n'; j++ {
+				if r == '"' && runes[j] == '\\' && j+1 < len(runes) {
+					j++
+				}
+			}
+			if j >= len(runes) || runes[j] != r {
+				return nil, &SyntaxError{Line: line, Column: column, Msg: "unterminated string"}
+			}
+
+			start.kind = tokenString
+			if r == '"' {
+				start.text, err = strconv.Unquote(string(runes[i : j+1]))
+				if err != nil {
+					return nil, &SyntaxError{Line: line, Column: column, Msg: "invalid string escape"}
+				}
+			} else {
+				start.text = string(runes[i+1 : j])
+			}
+			column += j + 1 - i
+			i = j + 1
+
+		case unicode.IsDigit(r):
+			j := i + 1
+			for ; j < len(runes) && (runes[j] == '.' || unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j])); j++ {
+			}
+
+			start.kind = tokenNumber
+			start.text = string(runes[i:j])
+			column += j - i
+			i = j
+
+		case unicode.IsLetter(r) || r == '_':
+			j := i + 1
+			for ; j < len(runes) && (runes[j] == '_' || unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j])); j++ {
+			}
+
+			start.kind = tokenIdent
+			start.text = string(runes[i:j])
+			column += j - i
+			i = j
+
+		default:
+			rest := string(runes[i:])
+			index := slices.IndexFunc(operators, func(op string) bool { return strings.HasPrefix(rest, op) })
+			if index == -1 {
+				return nil, &SyntaxError{Line: line, Column: column, Msg: fmt.Sprintf("unexpected character '%c'", r)}
+			}
+
+			start.kind = tokenOperator
+			start.text = operators[index]
+			column += len(start.text)
+			i += len(start.text)
+		}
+
+		tokens = append(tokens, start)
+	}
+
+	tokens = append(tokens, token{kind: tokenEOF, line: line, column: column})
+	return
+}
+
+type fieldType uint8
+
+const (
+	stringField fieldType = iota // All the string fields are treated as the lists.
+	timeField
+	numberField
+	boolField
+)
+
+type field struct {
+	typ     fieldType
+	strings func(*Email) []string
+	time    func(*Email) time.Time
+	number  func(*Email) float64
+	bool    func(*Email) bool
+}
+
+func addrsField(get func(*Email) []Address) field {
+	return field{typ: stringField, strings: func(e *Email) []string {
+		addrs := get(e)
+		values := make([]string, len(addrs))
+		for i, addr := range addrs {
+			values[i] = addr.Addr
+		}
+		return values
+	}}
+}
+
+func stringField1(get func(*Email) string) field {
+	return field{typ: stringField, strings: func(e *Email) []string { return []string{get(e)} }}
+}
+
+func timeField1(get func(*Email) time.Time) field {
+	return field{typ: timeField, time: get}
+}
+
+var fields = map[string]field{
+	"from":    addrsField(func(e *Email) []Address { return e.Froms }),
+	"sender":  addrsField(func(e *Email) []Address { return e.Senders }),
+	"to":      addrsField(func(e *Email) []Address { return e.To }),
+	"cc":      addrsField(func(e *Email) []Address { return e.Cc }),
+	"subject": stringField1(func(e *Email) string { return e.Subject }),
+	"mailbox": stringField1(func(e *Email) string { return e.Mailbox() }),
+	"body":    stringField1(func(e *Email) string { return e.Text }),
+
+	"flags": {typ: stringField, strings: func(e *Email) []string { return e.Flags }},
+	"attachments": {typ: stringField, strings: func(e *Email) []string {
+		names := make([]string, len(e.Attachments))
+		for i, attachment := range e.Attachments {
+			names[i] = attachment.Filename
+		}
+		return names
+	}},
+
+	"date":     timeField1(func(e *Email) time.Time { return e.Date() }),
+	"sent":     timeField1(func(e *Email) time.Time { return e.SentDate }),
+	"received": timeField1(func(e *Email) time.Time { return e.RecievedDate }),
+
+	"size": {typ: numberField, number: func(e *Email) float64 { return float64(e.Size) }},
+	"read": {typ: boolField, bool: func(e *Email) bool { return e.IsRead() }},
+}
+
+func headerField(name string) field {
+	return field{typ: stringField, strings: func(e *Email) []string { return e.Header.Values(name) }}
+}
+
+type node interface {
+	match(*Email) bool
+}
+
+type parser struct {
+	tokens []token
+	index  int
+}
+
+func (p *parser) peek() token { return p.tokens[p.index] }
+
+func (p *parser) next() (t token) {
+	if t = p.tokens[p.index]; t.kind != tokenEOF {
+		p.index++
+	}
+	return
+}
+
+func (p *parser) errorf(t token, format string, args ...interface{}) error {
+	return &SyntaxError{Line: t.line, Column: t.column, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *parser) expect(kind tokenKind, text string) (t token, err error) {
+	if t = p.next(); !t.is(kind, text) {
+		err = p.errorf(t, "expect '%s', but got %s", text, t)
+	}
+	return
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek().is(tokenIdent, "or") || p.peek().is(tokenOperator, "||") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left: left, right: right}
+	}
+
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek().is(tokenIdent, "and") || p.peek().is(tokenOperator, "&&") {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left: left, right: right}
+	}
+
+	return left, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.peek().is(tokenIdent, "not") || p.peek().is(tokenOperator, "!") {
+		p.next()
+		n, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{node: n}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t := p.next()
+	switch {
+	case t.is(tokenOperator, "("):
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if _, err = p.expect(tokenOperator, ")"); err != nil {
+			return nil, err
+		}
+		return n, nil
+
+	case t.kind == tokenIdent:
+		f, err := p.parseField(t)
+		if err != nil {
+			return nil, err
+		}
+		return p.parseComparison(t, f)
+
+	default:
+		return nil, p.errorf(t, "unexpected %s", t)
+	}
+}
+
+func (p *parser) parseField(t token) (f field, err error) {
+	name := strings.ToLower(t.text)
+	if name == "header" {
+		if _, err = p.expect(tokenOperator, "("); err != nil {
+			return
+		}
+
+		arg := p.next()
+		if arg.kind != tokenString {
+			err = p.errorf(arg, "expect the header name string, but got %s", arg)
+			return
+		}
+
+		if _, err = p.expect(tokenOperator, ")"); err != nil {
+			return
+		}
+
+		return headerField(arg.text), nil
+	}
+
+	f, ok := fields[name]
+	if !ok {
+		err = p.errorf(t, "unknown field '%s'", t.text)
+	}
+	return
+}
+
+func (p *parser) parseComparison(name token, f field) (node, error) {
+	if f.typ == boolField {
+		return boolNode{get: f.bool}, nil
+	}
+
+	op := p.next()
+	switch f.typ {
+	case stringField:
+		if !op.is(tokenOperator, "==", "!=", "=~", "!~") && !op.is(tokenIdent, "contains", "glob") {
+			return nil, p.errorf(op, "invalid operator %s for the string field '%s'", op, name.text)
+		}
+
+		value := p.next()
+		if value.kind != tokenString {
+			return nil, p.errorf(value, "expect a string, but got %s", value)
+		}
+		return newStringNode(p, f, op, value)
+
+	case timeField:
+		if !op.is(tokenOperator, "==", "!=", "<", "<=", ">", ">=") {
+			return nil, p.errorf(op, "invalid operator %s for the time field '%s'", op, name.text)
+		}
+
+		value, err := p.parseTimeValue()
+		if err != nil {
+			return nil, err
+		}
+		return timeNode{get: f.time, op: op.text, value: value}, nil
+
+	default:
+		if !op.is(tokenOperator, "==", "!=", "<", "<=", ">", ">=") {
+			return nil, p.errorf(op, "invalid operator %s for the number field '%s'", op, name.text)
+		}
+
+		value := p.next()
+		if value.kind != tokenNumber {
+			return nil, p.errorf(value, "expect a number, but got %s", value)
+		}
+
+		number, err := parseSize(value.text)
+		if err != nil {
+			return nil, p.errorf(value, "invalid number %s", value)
+		}
+		return numberNode{get: f.number, op: op.text, value: number}, nil
+	}
+}
+
+func (p *parser) parseTimeValue() (value timeValue, err error) {
+	t := p.next()
+	switch {
+	case t.kind == tokenString:
+		for _, layout := range timeLayouts {
+			if value.time, err = time.ParseInLocation(layout, t.text, time.Local); err == nil {
+				return
+			}
+		}
+		err = p.errorf(t, "invalid time %s", t)
+
+	case t.is(tokenIdent, "now"):
+		value.now = true
+		if sign := p.peek(); sign.is(tokenOperator, "+", "-") {
+			p.next()
+
+			d := p.next()
+			if d.kind != tokenNumber {
+				err = p.errorf(d, "expect a duration, but got %s", d)
+				return
+			}
+
+			if value.offset, err = parseDuration(d.text); err != nil {
+				err = p.errorf(d, "invalid duration %s", d)
+				return
+			}
+
+			if sign.text == "-" {
+				value.offset = -value.offset
+			}
+		}
+
+	default:
+		err = p.errorf(t, "expect a time string or now, but got %s", t)
+	}
+
+	return
+}
+
+var timeLayouts = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02"}
+
+// parseDuration is the same as time.ParseDuration, but also supports
+// the unit "d" (day), such as "1d12h".
+func parseDuration(s string) (d time.Duration, err error) {
+	if index := strings.IndexByte(s, 'd'); index > 0 {
+		days, err := strconv.ParseUint(s[:index], 10, 32)
+		if err != nil {
+			return 0, err
+		}
+
+		d = time.Duration(days) * 24 * time.Hour
+		if s = s[index+1:]; s == "" {
+			return d, nil
+		}
+	}
+
+	_d, err := time.ParseDuration(s)
+	return d + _d, err
+}
+
+var sizeUnits = map[string]float64{
+	"":  1,
+	"b": 1,
+	"k": 1 << 10, "kb": 1 << 10,
+	"m": 1 << 20, "mb": 1 << 20,
+	"g": 1 << 30, "gb": 1 << 30,
+}
+
+// parseSize parses the number with the optional size unit, such as "10KB".
+func parseSize(s string) (float64, error) {
+	index := strings.IndexFunc(s, unicode.IsLetter)
+	if index == -1 {
+		index = len(s)
+	}
+
+	unit, ok := sizeUnits[strings.ToLower(s[index:])]
+	if !ok {
+		return 0, fmt.Errorf("unknown size unit '%s'", s[index:])
+	}
+
+	number, err := strconv.ParseFloat(s[:index], 64)
+	return number * unit, err
+}
+
+type andNode struct{ left, right node }
+type orNode struct{ left, right node }
+type notNode struct{ node node }
+type boolNode struct{ get func(*Email) bool }
+
+func (n andNode) match(e *Email) bool  { return n.left.match(e) && n.right.match(e) }
+func (n orNode) match(e *Email) bool   { return n.left.match(e) || n.right.match(e) }
+func (n notNode) match(e *Email) bool  { return !n.node.match(e) }
+func (n boolNode) match(e *Email) bool { return n.get(e) }
+
+type stringNode struct {
+	get    func(*Email) []string
+	match1 func(string) bool
+	negate bool
+}
+
+func newStringNode(p *parser, f field, op, value token) (node, error) {
+	n := stringNode{get: f.strings}
+	switch opname := strings.ToLower(op.text); opname {
+	case "==", "!=":
+		n.negate = opname == "!="
+		n.match1 = func(s string) bool { return s == value.text }
+
+	case "contains":
+		n.match1 = func(s string) bool { return strings.Contains(s, value.text) }
+
+	case "=~", "!~", "glob":
+		pattern := value.text
+		if opname == "glob" {
+			pattern = globToRegexp(pattern)
+		}
+
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, p.errorf(value, "invalid pattern: %s", err)
+		}
+
+		n.negate = opname == "!~"
+		n.match1 = re.MatchString
+	}
+	return n, nil
+}
+
+func (n stringNode) match(e *Email) bool {
+	return slices.ContainsFunc(n.get(e), n.match1) != n.negate
+}
+
+// globToRegexp converts the glob pattern, which supports "*", "?",
+// "[...]" and "[!...]", to the regular expression matching the whole string.
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	b.WriteByte('^')
+	for i := 0; i < len(glob); {
+		c, size := utf8.DecodeRuneInString(glob[i:])
+		switch c {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteByte('.')
+		case '[':
+			start := i + 1
+			negate := start < len(glob) && glob[start] == '!'
+			if negate {
+				start++
+			}
+
+			if end := strings.IndexByte(glob[start:], ']'); end > 0 {
+				b.WriteByte('[')
+				if negate {
+					b.WriteByte('^')
+				}
+				b.WriteString(glob[start : start+end+1])
+				size = start + end + 1 - i
+			} else {
+				b.WriteString(`\[`)
+			}
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+		i += size
+	}
+	b.WriteByte('$')
+	return b.String()
+}
+
+type timeValue struct {
+	time   time.Time
+	now    bool
+	offset time.Duration
+}
+
+func (v timeValue) get() time.Time {
+	if v.now {
+		return time.Now().Add(v.offset)
+	}
+	return v.time
+}
+
+type timeNode struct {
+	get   func(*Email) time.Time
+	op    string
+	value timeValue
+}
+
+func (n timeNode) match(e *Email) bool {
+	return compare(n.op, n.get(e).Compare(n.value.get()))
+}
+
+type numberNode struct {
+	get   func(*Email) float64
+	op    string
+	value float64
+}
+
+func (n numberNode) match(e *Email) bool {
+	v := n.get(e)
+	switch {
+	case v < n.value:
+		return compare(n.op, -1)
+	case v > n.value:
+		return compare(n.op, 1)
+	default:
+		return compare(n.op, 0)
+	}
+}
+
+func compare(op string, result int) bool {
+	switch op {
+	case "==":
+		return result == 0
+	case "!=":
+		return result != 0
+	case "<":
+		return result < 0
+	case "<=":
+		return result <= 0
+	case ">":
+		return result > 0
+	case ">=":
+		return result >= 0
+	default:
+		panic(fmt.Errorf("unknown comparison operator '%s'", op))
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright 2023 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package email
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCompileMatcher(t *testing.T) {
+	email := &Email{
+		Subject:      `[ERROR] disk "/data" is full`,
+		Froms:        []Address{{Name: "Zabbix", Addr: "zabbix@example.com"}},
+		To:           []Address{{Addr: "ops@example.com"}, {Addr: "dev@example.com"}},
+		Flags:        []string{"\\Flagged"},
+		Size:         2048,
+		RecievedDate: time.Now().Add(-time.Minute),
+		Header:       map[string][]string{"X-Priority": {"1"}},
+		mailbox:      "INBOX",
+	}
+
+	tests := []struct {
+		expr  string
+		match bool
+	}{
+		// Precedence: not > and > or, and the parentheses.
+		{`subject contains "ERROR" or subject contains "x" and size > 1MB`, true},
+		{`(subject contains "ERROR" or subject contains "x") and size > 1MB`, false},
+		{`subject contains "x" and size > 1MB or mailbox == "INBOX"`, true},
+		{`not subject contains "x" and size > 1MB`, false},
+		{`not (subject contains "x" and size > 1MB)`, true},
+		{`not not read || ! read`, true},
+		{`read && size == 2KB || size != 2048`, false},
+
+		// Quoting: the double quotes with the escapes,
+		// and the single quotes without the escapes.
+		{`subject contains "\"/data\""`, true},
+		{`subject =~ '^\[ERROR\] disk "/\w+"'`, true},
+		{`subject =~ "^\\[ERROR\\]"`, true},
+		{`subject contains '\"'`, false},
+		{`subject == 'disk'`, false},
+
+		// The multi-value fields.
+		{`to == "dev@example.com"`, true},
+		{`to != "dev@example.com"`, false},
+		{`to !~ '@example\.org$'`, true},
+		{`from glob "*@example.com" and flags == "\\Flagged"`, true},
+		{`header("x-priority") == "1" and received > now - 1h`, true},
+		{`received > now - 1d and received < "2006-01-02"`, false},
+
+		// Glob.
+		{`mailbox glob "IN?OX"`, true},
+		{`mailbox glob "[A-Z]NBOX"`, true},
+		{`mailbox glob "[!A-Z]NBOX"`, false},
+		{`mailbox glob "[!a-z]NBOX"`, true},
+		{`mailbox glob "INBOX[!]"`, false},
+		{`mailbox glob "IN.OX"`, false},
+	}
+
+	for _, test := range tests {
+		match, err := CompileMatcher(test.expr)
+		if err != nil {
+			t.Errorf("'%s': unexpected error: %v", test.expr, err)
+		} else if result := match(email); result != test.match {
+			t.Errorf("'%s': expect %v, but got %v", test.expr, test.match, result)
+		}
+	}
+}
+
+func TestCompileMatcherSyntaxError(t *testing.T) {
+	tests := []struct {
+		expr         string
+		line, column int
+	}{
+		{`subject == `, 1, 12},
+		{`subject == "abc`, 1, 12},
+		{`subject == "\q"`, 1, 12},
+		{`subject == 'a' $`, 1, 16},
+		{`(subject == 'a'`, 1, 16},
+		{`subject == 'a')`, 1, 15},
+		{`foo == 'a'`, 1, 1},
+		{`subject > 'a'`, 1, 9},
+		{`subject =~ '('`, 1, 12},
+		{`header(x) == 'a'`, 1, 8},
+		{"subject == 'a' and\n  size > x", 2, 10},
+		{"subject == 'a'\nor\n\treceived > now - 1y", 3, 19},
+		{"size > 1\nand", 2, 4},
+	}
+
+	for _, test := range tests {
+		_, err := CompileMatcher(test.expr)
+
+		var serr *SyntaxError
+		if !errors.As(err, &serr) {
+			t.Errorf("'%s': expect a syntax error, but got %v", test.expr, err)
+		} else if serr.Line != test.line || serr.Column != test.column {
+			t.Errorf("'%s': expect the error at %d:%d, but got %d:%d (%s)",
+				test.expr, test.line, test.column, serr.Line, serr.Column, serr.Msg)
+		}
+	}
+}
+
+func TestGlobToRegexp(t *testing.T) {
+	tests := map[string]string{
+		"*@example.com": `^.*@example\.com$`,
+		"a?c":           `^a.c$`,
+		"[abc]*":        `^[abc].*$`,
+		"[!abc]*":       `^[^abc].*$`,
+		"[!]":           `^\[!\]$`,
+		"a[":            `^a\[$`,
+		"中?文":           `^中.文$`,
+	}
+
+	for glob, expect := range tests {
+		if re := globToRegexp(glob); re != expect {
+			t.Errorf("'%s': expect '%s', but got '%s'", glob, expect, re)
+		}
+	}
+}
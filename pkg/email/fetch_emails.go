@@ -19,9 +19,12 @@ import (
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log/slog"
+	"net/textproto"
 	"slices"
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/emersion/go-imap"
@@ -35,8 +38,12 @@ const (
 )
 
 var (
-	emailFetchItems1 = []imap.FetchItem{imap.FetchInternalDate, imap.FetchEnvelope, imap.FetchUid, imap.FetchFlags}
-	emailFetchItems2 = []imap.FetchItem{imap.FetchInternalDate, imap.FetchEnvelope, imap.FetchUid, imap.FetchFlags, imap.FetchBody}
+	emailFetchItems1 = []imap.FetchItem{imap.FetchInternalDate, imap.FetchEnvelope, imap.FetchUid, imap.FetchFlags, imap.FetchRFC822Size, emailHeaderSection.FetchItem()}
+	emailFetchItems2 = append(slices.Clip(emailFetchItems1), emailBodySection.FetchItem())
+
+	// Use BODY.PEEK[...] to fetch the message without setting \Seen flag.
+	emailBodySection   = &imap.BodySectionName{Peek: true}
+	emailHeaderSection = &imap.BodySectionName{Peek: true, BodyPartName: imap.BodyPartName{Specifier: imap.HeaderSpecifier}}
 
 	emailStoreItem = imap.FormatFlagsOp(imap.AddFlags, true)
 	emailReadFlags = []interface{}{imap.SeenFlag}
@@ -61,51 +68,108 @@ func (a Address) FullAddress() string {
 	return fmt.Sprintf("%s<%s>", a.Name, a.Addr)
 }
 
-var _ json.Marshaler = Address{}
+var (
+	_ json.Marshaler   = Address{}
+	_ json.Unmarshaler = &Address{}
+)
 
 // MarshalJSON implements the interface json.Marshaler.
 func (a Address) MarshalJSON() ([]byte, error) {
 	return json.Marshal(a.FullAddress())
 }
 
+// UnmarshalJSON implements the interface json.Unmarshaler,
+// which parses the full address returned by FullAddress.
+func (a *Address) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+
+	if index := strings.LastIndexByte(s, '<'); index > -1 && strings.HasSuffix(s, ">") {
+		a.Name, a.Addr = s[:index], s[index+1:len(s)-1]
+	} else {
+		a.Name, a.Addr = "", s
+	}
+	return nil
+}
+
 // Email represents an email message.
 type Email struct {
 	Froms        []Address
 	Senders      []Address
 	Subject      string
+	MessageID    string
 	SentDate     time.Time // The date when the message is sent.
 	RecievedDate time.Time // The date when the mail server recieves the message.
 
+	To     []Address
+	Cc     []Address
+	Flags  []string
+	Size   uint32 // The size of the whole message in bytes.
+	Header textproto.MIMEHeader
+
+	// Body is fetched and decoded only when fetching the emails with body.
+	Body
+
 	uid     uint32
 	read    bool
+	account string
 	mailbox string
 	client  *client.Client
+	ctx     context.Context
+
+	uidValidity uint32
 }
 
-func newEmail(client *client.Client, mailbox string, msg *imap.Message) (m Email) {
-	m.Senders = make([]Address, len(msg.Envelope.Sender))
-	for i, sender := range msg.Envelope.Sender {
-		m.Senders[i] = Address{
-			Name: sender.PersonalName,
-			Addr: sender.Address(),
+func newAddresses(addrs []*imap.Address) []Address {
+	addresses := make([]Address, len(addrs))
+	for i, addr := range addrs {
+		addresses[i] = Address{
+			Name: addr.PersonalName,
+			Addr: addr.Address(),
 		}
 	}
+	return addresses
+}
 
-	m.Froms = make([]Address, len(msg.Envelope.From))
-	for i, sender := range msg.Envelope.From {
-		m.Froms[i] = Address{
-			Name: sender.PersonalName,
-			Addr: sender.Address(),
-		}
-	}
+func newEmail(ctx context.Context, client *client.Client, account string,
+	mailbox *imap.MailboxStatus, msg *imap.Message) (m Email) {
+	m.Senders = newAddresses(msg.Envelope.Sender)
+	m.Froms = newAddresses(msg.Envelope.From)
+	m.To = newAddresses(msg.Envelope.To)
+	m.Cc = newAddresses(msg.Envelope.Cc)
 
 	m.Subject = msg.Envelope.Subject
+	m.MessageID = msg.Envelope.MessageId
 	m.SentDate = msg.Envelope.Date
 	m.RecievedDate = msg.InternalDate
 	m.read = slices.Contains(msg.Flags, imap.SeenFlag)
-	m.mailbox = mailbox
+	m.account = account
+	m.mailbox = mailbox.Name
 	m.client = client
+	m.ctx = ctx
 	m.uid = msg.Uid
+	m.uidValidity = mailbox.UidValidity
+	m.Flags = msg.Flags
+	m.Size = msg.Size
+
+	if literal := msg.GetBody(emailHeaderSection); literal != nil {
+		var err error
+		if m.Header, err = parseHeader(literal); err != nil {
+			slog.Warn("fail to parse the email header", "mailbox", m.mailbox,
+				"uid", m.uid, "sender", m.Sender(), "subject", m.Subject, "err", err)
+		}
+	}
+
+	if literal := msg.GetBody(emailBodySection); literal != nil {
+		var err error
+		if m.Body, err = parseBody(literal); err != nil {
+			slog.Warn("fail to parse the email body", "mailbox", m.mailbox,
+				"uid", m.uid, "sender", m.Sender(), "subject", m.Subject, "err", err)
+		}
+	}
+
 	return
 }
 
@@ -144,6 +208,21 @@ func (m Email) Date() (date time.Time) {
 // UID returns the uid of the email.
 func (m Email) UID() uint32 { return m.uid }
 
+// UIDValidity returns the UIDVALIDITY of the mailbox when fetching the email.
+func (m Email) UIDValidity() uint32 { return m.uidValidity }
+
+// Account returns the account identity of the email, see the function Account.
+func (m Email) Account() string { return m.account }
+
+// Context returns the context of the check fetching the email,
+// which should be used by the handlers to do the network i/o.
+func (m Email) Context() context.Context {
+	if m.ctx == nil {
+		return context.Background()
+	}
+	return m.ctx
+}
+
 // IsRead reports whether the message has been read.
 func (m Email) IsRead() bool { return m.read }
 
@@ -161,6 +240,7 @@ func (m *Email) SetRead() (err error) {
 	err = m.client.UidStore(seqSet, emailStoreItem, emailReadFlags, nil)
 	if err == nil {
 		m.read = true
+		m.Flags = append(slices.Clip(m.Flags), imap.SeenFlag)
 	}
 
 	return
@@ -182,17 +262,67 @@ func (m *Email) Move(box string) (err error) {
 	return
 }
 
+// Raw fetches the raw RFC 822 message from the server.
+//
+// It must be called before the message is moved to other mailbox.
+func (m *Email) Raw() (data []byte, err error) {
+	if status := m.client.Mailbox(); status == nil || status.Name != m.mailbox {
+		return nil, fmt.Errorf("mailbox '%s' is not selected", m.mailbox)
+	}
+
+	seqSet := new(imap.SeqSet)
+	seqSet.AddNum(m.uid)
+	messages := make(chan *imap.Message, 1)
+	err = m.client.UidFetch(seqSet, []imap.FetchItem{emailBodySection.FetchItem()}, messages)
+	if err != nil {
+		return
+	}
+
+	msg := <-messages
+	if msg == nil {
+		return nil, fmt.Errorf("no message with uid %d", m.uid)
+	}
+
+	literal := msg.GetBody(emailBodySection)
+	if literal == nil {
+		return nil, fmt.Errorf("no body of message with uid %d", m.uid)
+	}
+	return io.ReadAll(literal)
+}
+
 // FetchEmails fetches the emails from the mailbox.
 //
 // If mailbox is eqial to "", use Inbox instead.
 // If maxnum is equal 0, use 100 instead.
 func FetchEmails(ctx context.Context, addr, username, password, mailbox string,
 	tlsconfig *tls.Config, maxnum uint32, chains ...Handler) (emails []Email, goon bool, err error) {
-	return fetchEmails(ctx, addr, username, password, mailbox, tlsconfig, false, maxnum, chains...)
+	return fetchEmails(ctx, addr, username, password, mailbox, tlsconfig, false, maxnum, nil, chains...)
+}
+
+// FetchNewEmails is the same as FetchEmails, but only fetches the emails
+// arriving after the last fetch, by tracking UIDVALIDITY and the highest
+// seen UID of the mailbox in states.
+//
+// For the first fetch or when UIDVALIDITY changes, the mailbox is resynced
+// and only the latest maxnum emails are fetched. Or, the earliest maxnum new
+// emails are fetched and goon is set to true if there are more new emails.
+func FetchNewEmails(ctx context.Context, addr, username, password, mailbox string,
+	tlsconfig *tls.Config, maxnum uint32, states StateStore, chains ...Handler) (
+	emails []Email, goon bool, err error) {
+	if states == nil {
+		panic("FetchNewEmails: states must not be nil")
+	}
+	return fetchEmails(ctx, addr, username, password, mailbox, tlsconfig, false, maxnum, states, chains...)
+}
+
+// Account returns the account identity of the email username on the server,
+// which is used as the key to store the states of the mailboxes.
+func Account(addr, username string) string {
+	return fmt.Sprintf("%s@%s", username, addr)
 }
 
 func fetchEmails(ctx context.Context, addr, username, password, mailbox string,
-	tlsconfig *tls.Config, body bool, maxnum uint32, chains ...Handler) (
+	tlsconfig *tls.Config, body bool, maxnum uint32, states StateStore, chains ...Handler) (
 	emails []Email, goon bool, err error) {
 
 	if addr == "" {
@@ -205,27 +335,25 @@ func fetchEmails(ctx context.Context, addr, username, password, mailbox string,
 		panic("email password must not be empty")
 	}
 
-	var imapClient *client.Client
-	if tlsconfig != nil {
-		imapClient, err = client.DialTLS(addr, tlsconfig)
-	} else {
-		imapClient, err = client.Dial(addr)
-	}
+	imapClient, err := dial(addr, username, password, tlsconfig)
 	if err != nil {
 		return
 	}
 	defer imapClient.Terminate()
-
-	err = imapClient.Login(username, password)
-	if err != nil {
-		return
-	}
 	defer imapClient.Logout()
 
 	if mailbox == "" {
 		mailbox = Inbox
 	}
 
+	return fetchMailboxEmails(ctx, imapClient, Account(addr, username),
+		mailbox, body, maxnum, states, chains)
+}
+
+func fetchMailboxEmails(ctx context.Context, imapClient *client.Client,
+	account, mailbox string, body bool, maxnum uint32, states StateStore,
+	chains []Handler) (emails []Email, goon bool, err error) {
+
 	mailboxStatus, err := imapClient.Select(mailbox, false)
 	if err != nil {
 		return
@@ -235,22 +363,48 @@ func fetchEmails(ctx context.Context, addr, username, password, mailbox string,
 		maxnum = 100
 	}
 
-	var startid uint32
-	stopid := mailboxStatus.Messages
-	if stopid > maxnum {
-		startid = stopid - maxnum - 1
+	var state MailboxState
+	seqset := new(imap.SeqSet)
+	if states == nil {
+		var startid uint32
+		stopid := mailboxStatus.Messages
+		if stopid > maxnum {
+			startid = stopid - maxnum - 1
+		}
+		seqset.AddRange(startid, stopid)
+	} else {
+		state, err = states.LoadState(account, mailbox)
+		if err != nil {
+			return
+		}
+
+		var uids []uint32
+		uids, goon, err = searchNewUIDs(imapClient, account, mailboxStatus, &state, maxnum)
+		if err != nil {
+			return
+		} else if len(uids) == 0 {
+			err = states.SaveState(account, mailbox, state)
+			return
+		}
+
+		seqset.AddNum(uids...)
+		state.LastUID = uids[len(uids)-1]
 	}
 
-	done := make(chan error)
+	done := make(chan error, 1)
 	emails = make([]Email, 0, maxnum)
 	messages := make(chan *imap.Message, maxnum)
 
 	defer func() {
-		for _, email := range emails {
-			if !email.IsRead() {
-				goon = true
-				break
+		if states == nil {
+			for _, email := range emails {
+				if !email.IsRead() {
+					goon = true
+					break
+				}
 			}
+		} else if err == nil {
+			err = states.SaveState(account, mailbox, state)
 		}
 
 		_emails := make([]Email, 0, len(emails))
@@ -272,9 +426,11 @@ func fetchEmails(ctx context.Context, addr, username, password, mailbox string,
 			fetchItems = emailFetchItems2
 		}
 
-		seqset := new(imap.SeqSet)
-		seqset.AddRange(startid, stopid)
-		done <- imapClient.Fetch(seqset, fetchItems, messages)
+		if states == nil {
+			done <- imapClient.Fetch(seqset, fetchItems, messages)
+		} else {
+			done <- imapClient.UidFetch(seqset, fetchItems, messages)
+		}
 	}()
 
 	for {
@@ -292,11 +448,56 @@ func fetchEmails(ctx context.Context, addr, username, password, mailbox string,
 			if !ok {
 				return
 			}
-			emails = append(emails, newEmail(imapClient, mailbox, msg))
+			emails = append(emails, newEmail(ctx, imapClient, account, mailboxStatus, msg))
 		}
 	}
 }
 
+// searchNewUIDs searches the uids of the new emails in the selected mailbox
+// in ascending order, and resets the state if UIDVALIDITY has changed.
+func searchNewUIDs(imapClient *client.Client, account string,
+	mailboxStatus *imap.MailboxStatus, state *MailboxState, maxnum uint32) (
+	uids []uint32, goon bool, err error) {
+
+	if state.UIDValidity != mailboxStatus.UidValidity {
+		if state.UIDValidity != 0 {
+			slog.Warn("uidvalidity of the mailbox has changed, and resync it",
+				"account", account, "mailbox", mailboxStatus.Name,
+				"olduidvalidity", state.UIDValidity,
+				"newuidvalidity", mailboxStatus.UidValidity)
+		}
+		*state = MailboxState{UIDValidity: mailboxStatus.UidValidity}
+	}
+
+	if mailboxStatus.Messages == 0 {
+		return
+	} else if mailboxStatus.UidNext > 0 && mailboxStatus.UidNext <= state.LastUID+1 {
+		return
+	}
+
+	criteria := imap.NewSearchCriteria()
+	criteria.Uid = new(imap.SeqSet)
+	criteria.Uid.AddRange(state.LastUID+1, 0)
+	if uids, err = imapClient.UidSearch(criteria); err != nil {
+		return
+	}
+
+	// "UID n:*" always contains the last message even if its uid is less than n.
+	uids = slices.DeleteFunc(uids, func(uid uint32) bool { return uid <= state.LastUID })
+	slices.Sort(uids)
+
+	if _len := uint32(len(uids)); _len > maxnum {
+		if state.LastUID == 0 {
+			uids = uids[_len-maxnum:]
+		} else {
+			uids = uids[:maxnum]
+			goon = true
+		}
+	}
+
+	return
+}
+
 func handleEmailMessage(e *Email, chains []Handler) bool {
 	for _, h := range chains {
 		next, err := h.Handle(e)
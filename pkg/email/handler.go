@@ -15,9 +15,11 @@
 package email
 
 import (
+	"bytes"
 	"fmt"
 	"log/slog"
 	"regexp"
+	"strings"
 	"time"
 
 	"github.com/xgfone/go-binder"
@@ -50,24 +52,22 @@ func matchEmail(senderRegexp, subjectRegexp string) (match func(sender, subject
 	return
 }
 
-func buildOrMatcher(matchers []matcher) (match func(sender, subject string) bool, err error) {
+func buildOrMatcher(matchers []matcher) (match Matcher, err error) {
 	_len := len(matchers)
 	if _len == 0 {
 		return nil, fmt.Errorf("missing the matcher")
 	}
 
-	matches := make([]func(sender, subject string) (match bool), _len)
+	matches := make([]Matcher, _len)
 	for i, m := range matchers {
-		match, err := EmailMatcher(m.Sender, m.Subject)
-		if err != nil {
-			return nil, err
+		if matches[i], err = m.build(); err != nil {
+			return nil, fmt.Errorf("invalid matcher #%d: %w", i, err)
 		}
-		matches[i] = match
 	}
 
-	return func(sender, subject string) bool {
+	return func(e *Email) bool {
 		for _, m := range matches {
-			if m(sender, subject) {
+			if m(e) {
 				return true
 			}
 		}
@@ -80,11 +80,53 @@ var builders = make(map[string]HandlerBuilder, 8)
 type matcher struct {
 	Sender  string
 	Subject string
+
+	// Expr is the boolean expression compiled by CompileMatcher,
+	// which is ANDed with Sender and Subject if they are set.
+	Expr string
+}
+
+func (m matcher) build() (Matcher, error) {
+	match, err := EmailMatcher(m.Sender, m.Subject)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.Expr == "" {
+		return func(e *Email) bool { return match(e.Sender(), e.Subject) }, nil
+	}
+
+	exprMatch, err := CompileMatcher(m.Expr)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(e *Email) bool {
+		return match(e.Sender(), e.Subject) && exprMatch(e)
+	}, nil
 }
 
 func init() {
 	RegisterHandlerBuilder(FilterAlarmedHandler().Type(), func(configs map[string]interface{}) (Handler, error) {
-		return FilterAlarmedHandler(), nil
+		var config struct {
+			// Store is the type of the alarm store, "memory", "file" or "bolt",
+			// which is built with the same configs.
+			//
+			// Default: "memory"
+			Store string
+		}
+		if err := binder.BindStructToMap(&config, "json", configs); err != nil {
+			return nil, err
+		}
+		if config.Store == "" {
+			config.Store = "memory"
+		}
+
+		store, err := BuildAlarmStore(config.Store, configs)
+		if err != nil {
+			return nil, err
+		}
+		return FilterAlarmedStoreHandler(store), nil
 	})
 
 	RegisterHandlerBuilder(FilterReadHandler().Type(), func(map[string]interface{}) (Handler, error) {
@@ -123,6 +165,35 @@ func init() {
 
 		return MoveBoxHandler(config.Mailbox, match), nil
 	})
+
+	RegisterHandlerBuilder(ForwardHandler(SMTPConfig{}, "", nil, nil).Type(), func(configs map[string]interface{}) (Handler, error) {
+		var config struct {
+			SMTPConfig
+
+			From     string    `validate:"required"`
+			To       []string  `validate:"required"`
+			Matchers []matcher // If empty, forward all the emails.
+		}
+		if err := binder.BindStructToMap(&config, "json", configs); err != nil {
+			return nil, err
+		}
+		if err := config.SMTPConfig.Check(); err != nil {
+			return nil, err
+		}
+		if config.From == "" || len(config.To) == 0 {
+			return nil, fmt.Errorf("missing the forward sender or recipients")
+		}
+
+		var match Matcher
+		if len(config.Matchers) > 0 {
+			var err error
+			if match, err = buildOrMatcher(config.Matchers); err != nil {
+				return nil, err
+			}
+		}
+
+		return ForwardHandler(config.SMTPConfig, config.From, config.To, match), nil
+	})
 }
 
 // GetHandlerBuilder returns the handler builder by the type.
@@ -179,9 +250,9 @@ func NewHandler(_type string, handle func(*Email) (next bool, err error)) Handle
 }
 
 // SetReadHandler returns an email handler to set the email to read.
-func SetReadHandler(match func(sender, subject string) bool) Handler {
+func SetReadHandler(match Matcher) Handler {
 	return NewHandler("setread", func(e *Email) (next bool, err error) {
-		if !e.IsRead() && match(e.Sender(), e.Subject) {
+		if !e.IsRead() && match(e) {
 			err = e.SetRead()
 			slog.Info("set email to read", "mailbox", e.Mailbox(),
 				"uid", e.uid, "sender", e.Sender(), "subject", e.Subject,
@@ -201,10 +272,10 @@ func FilterReadHandler() Handler {
 }
 
 // MoveBoxHandler returns an email handler to move the matched email to other mailbox.
-func MoveBoxHandler(mailbox string, match func(sender, subject string) bool) Handler {
+func MoveBoxHandler(mailbox string, match Matcher) Handler {
 	return NewHandler("movebox", func(e *Email) (next bool, err error) {
 		srcbox := e.Mailbox()
-		if match(e.Sender(), e.Subject) {
+		if match(e) {
 			err = e.Move(mailbox)
 			slog.Info("move email", "srcmailbox", srcbox, "newmailbox", mailbox,
 				"uid", e.uid, "sender", e.Sender(), "subject", e.Subject,
@@ -216,16 +287,49 @@ func MoveBoxHandler(mailbox string, match func(sender, subject string) bool) Han
 	})
 }
 
+// ForwardHandler returns an email handler to forward the raw message
+// of the matched email to the recipients by the SMTP server,
+// which adds the Resent-* header fields and keeps the original message.
+// The forwarding is bound to the context of the check, see Email.Context.
+//
+// If match is nil, forward all the emails. config must have been checked.
+func ForwardHandler(config SMTPConfig, from string, to []string, match Matcher) Handler {
+	return NewHandler("forward", func(e *Email) (next bool, err error) {
+		next = true
+		if match != nil && !match(e) {
+			return
+		}
+
+		raw, err := e.Raw()
+		if err != nil {
+			return
+		}
+
+		var buf bytes.Buffer
+		buf.Grow(len(raw) + 256)
+		fmt.Fprintf(&buf, "Resent-From: %s\r\n", from)
+		fmt.Fprintf(&buf, "Resent-To: %s\r\n", strings.Join(to, ", "))
+		fmt.Fprintf(&buf, "Resent-Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+		buf.Write(raw)
+
+		err = SendMail(e.Context(), config, from, to, buf.Bytes())
+		slog.Info("forward email", "mailbox", e.Mailbox(), "uid", e.uid,
+			"sender", e.Sender(), "subject", e.Subject, "to", to, "err", err)
+		return
+	})
+}
+
 // FilterAlarmedHandler returns an email handler to filter the alarmed email
-// based on the memory.
+// based on the memory, which keeps at most DefaultAlarmMaxSize emails
+// for DefaultAlarmTTL.
 func FilterAlarmedHandler() Handler {
-	caches := make(map[string]struct{}, 256)
+	return FilterAlarmedStoreHandler(NewMemoryAlarmStore(0, 0))
+}
+
+// FilterAlarmedStoreHandler returns an email handler to filter the alarmed
+// email based on the alarm store, which uses AlarmKey as the key.
+func FilterAlarmedStoreHandler(store AlarmStore) Handler {
 	return NewHandler("filteralarmed", func(e *Email) (next bool, err error) {
-		key := fmt.Sprintf("%d_%s", e.UID(), e.Date().Format(time.RFC3339))
-		_, ok := caches[key]
-		if next = !ok; next {
-			caches[key] = struct{}{}
-		}
-		return
+		return store.Mark(AlarmKey(e))
 	})
 }
@@ -16,7 +16,9 @@ package email
 
 import (
 	"context"
+	"crypto/tls"
 	"slices"
+	"strings"
 
 	"github.com/emersion/go-imap"
 	"github.com/emersion/go-imap/client"
@@ -26,6 +28,13 @@ import (
 type Mailbox struct {
 	Name        string
 	HasChildren bool
+	NoSelect    bool // The mailbox cannot be selected.
+}
+
+// IsWildcardMailbox reports whether the mailbox name contains
+// the LIST-style wildcards, "*" or "%".
+func IsWildcardMailbox(mailbox string) bool {
+	return strings.ContainsAny(mailbox, "*%")
 }
 
 // GetMailBoxes returns all the sub-mailboxes belonging on mailbox.
@@ -36,7 +45,7 @@ type Mailbox struct {
 //
 //	GetMailBoxes(context.Background(), "*", true)
 //	GetMailBoxes(context.Background(), "Archives.*", true)
-func GetMailBoxes(ctx context.Context, addr, username, password, mailbox string, tls bool) (mailboxes []Mailbox, err error) {
+func GetMailBoxes(ctx context.Context, addr, username, password, mailbox string, useTLS bool) (mailboxes []Mailbox, err error) {
 	if addr == "" {
 		panic("mail server address must not be empty")
 	}
@@ -47,29 +56,34 @@ func GetMailBoxes(ctx context.Context, addr, username, password, mailbox string,
 		panic("email password must not be empty")
 	}
 
-	var imapClient *client.Client
-	if tls {
-		imapClient, err = client.DialTLS(addr, nil)
-	} else {
-		imapClient, err = client.Dial(addr)
-	}
-	if err != nil {
-		return
+	var tlsconfig *tls.Config
+	if useTLS {
+		tlsconfig = new(tls.Config)
 	}
-	defer imapClient.Terminate()
 
-	err = imapClient.Login(username, password)
+	imapClient, err := dial(addr, username, password, tlsconfig)
 	if err != nil {
 		return
 	}
+	defer imapClient.Terminate()
 	defer imapClient.Logout()
 
+	return listMailboxes(ctx, imapClient, mailbox)
+}
+
+// GetMailBoxes is the same as the function GetMailBoxes,
+// but uses the current connection.
+func (c *Conn) GetMailBoxes(ctx context.Context, mailbox string) ([]Mailbox, error) {
+	return listMailboxes(ctx, c.client, mailbox)
+}
+
+func listMailboxes(ctx context.Context, imapClient *client.Client, mailbox string) (mailboxes []Mailbox, err error) {
 	if mailbox == "" {
 		mailbox = "*"
 	}
 
 	mbinfos := make(chan *imap.MailboxInfo, 10)
-	done := make(chan error)
+	done := make(chan error, 1)
 	go func() { done <- imapClient.List("", mailbox, mbinfos) }()
 
 	for {
@@ -90,6 +104,7 @@ func GetMailBoxes(ctx context.Context, addr, username, password, mailbox string,
 
 			mailboxes = append(mailboxes, Mailbox{
 				HasChildren: slices.Contains(mi.Attributes, imap.HasChildrenAttr),
+				NoSelect:    slices.Contains(mi.Attributes, imap.NoSelectAttr),
 				Name:        mi.Name,
 			})
 		}
@@ -0,0 +1,317 @@
+// Copyright 2023 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package email
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/textproto"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// OutboxEntry is a batch of the emails whose notice is pending to be sent.
+type OutboxEntry struct {
+	ID      string
+	Owner   string // The owner of the entry, such as the id of the controller.
+	Mailbox string // The configured mailbox name, which may be a wildcard.
+	Emails  []Email
+
+	Created  time.Time
+	Attempts int
+
+	// Until is the time until which the notice is held, such as
+	// in the quiet hours of the notifier. Zero means not held.
+	Until time.Time
+
+	// Sent is the notifiers which have sent or held the notice,
+	// so that it is only redelivered by the others.
+	//
+	// If Notifier is set, the notice is only redelivered by the notifier,
+	// such as the one which has held it.
+	Sent     []string
+	Notifier string
+}
+
+// Outbox is used to store the pending notices durably, so that they can be
+// redelivered after the notifiers fail or the process restarts.
+type Outbox interface {
+	// Put adds the entry, or replaces the entry with the same id.
+	Put(entry OutboxEntry) error
+
+	// Remove removes the entry by the id, which does nothing if not exist.
+	Remove(id string) error
+
+	// Pending returns all the entries belonging to the owner,
+	// which are sorted by the created time.
+	Pending(owner string) ([]OutboxEntry, error)
+}
+
+type outboxEntries map[string]OutboxEntry
+
+func (es outboxEntries) pending(owner string) []OutboxEntry {
+	entries := make([]OutboxEntry, 0, 4)
+	for _, entry := range es {
+		if entry.Owner == owner {
+			entries = append(entries, entry)
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Created.Before(entries[j].Created)
+	})
+	return entries
+}
+
+// NewMemoryOutbox returns a new outbox based on the memory,
+// which is not durable across the restart.
+func NewMemoryOutbox() Outbox {
+	return &memoryOutbox{entries: make(outboxEntries, 4)}
+}
+
+type memoryOutbox struct {
+	lock    sync.Mutex
+	entries outboxEntries
+}
+
+func (o *memoryOutbox) Put(entry OutboxEntry) error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.entries[entry.ID] = entry
+	return nil
+}
+
+func (o *memoryOutbox) Remove(id string) error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	delete(o.entries, id)
+	return nil
+}
+
+func (o *memoryOutbox) Pending(owner string) ([]OutboxEntry, error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return o.entries.pending(owner), nil
+}
+
+// NewFileOutbox returns a new outbox based on the json file,
+// which loads the entries from the file if it exists.
+func NewFileOutbox(filepath string) (Outbox, error) {
+	if filepath == "" {
+		panic("NewFileOutbox: filepath must not be empty")
+	}
+
+	o := &fileOutbox{filepath: filepath, entries: make(outboxEntries, 4)}
+	data, err := os.ReadFile(filepath)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return nil, err
+	case len(data) > 0:
+		var entries map[string]storedOutboxEntry
+		if err = json.Unmarshal(data, &entries); err != nil {
+			return nil, err
+		}
+		for id, entry := range entries {
+			o.entries[id] = entry.entry()
+		}
+	}
+	return o, nil
+}
+
+type fileOutbox struct {
+	filepath string
+
+	lock    sync.Mutex
+	entries outboxEntries
+}
+
+func (o *fileOutbox) Put(entry OutboxEntry) error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	old, exist := o.entries[entry.ID]
+	o.entries[entry.ID] = entry
+	if err := o.flush(); err != nil {
+		if exist {
+			o.entries[entry.ID] = old
+		} else {
+			delete(o.entries, entry.ID)
+		}
+		return err
+	}
+	return nil
+}
+
+func (o *fileOutbox) Remove(id string) error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	if _, ok := o.entries[id]; !ok {
+		return nil
+	}
+
+	delete(o.entries, id)
+	return o.flush()
+}
+
+func (o *fileOutbox) Pending(owner string) ([]OutboxEntry, error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return o.entries.pending(owner), nil
+}
+
+func (o *fileOutbox) flush() error {
+	entries := make(map[string]storedOutboxEntry, len(o.entries))
+	for id, entry := range o.entries {
+		entries[id] = newStoredOutboxEntry(entry)
+	}
+
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(o.filepath, data)
+}
+
+// storedOutboxEntry is the persistence format of OutboxEntry,
+// which keeps the private fields of the emails, unlike Email.MarshalJSON.
+type storedOutboxEntry struct {
+	ID      string
+	Owner   string
+	Mailbox string
+	Emails  []storedEmail
+
+	Created  time.Time
+	Attempts int
+	Until    time.Time
+	Sent     []string `json:",omitempty"`
+	Notifier string   `json:",omitempty"`
+}
+
+func newStoredOutboxEntry(entry OutboxEntry) storedOutboxEntry {
+	emails := make([]storedEmail, len(entry.Emails))
+	for i, e := range entry.Emails {
+		emails[i] = newStoredEmail(e)
+	}
+
+	return storedOutboxEntry{
+		ID:       entry.ID,
+		Owner:    entry.Owner,
+		Mailbox:  entry.Mailbox,
+		Emails:   emails,
+		Created:  entry.Created,
+		Attempts: entry.Attempts,
+		Until:    entry.Until,
+		Sent:     entry.Sent,
+		Notifier: entry.Notifier,
+	}
+}
+
+func (e storedOutboxEntry) entry() OutboxEntry {
+	emails := make([]Email, len(e.Emails))
+	for i, email := range e.Emails {
+		emails[i] = email.email()
+	}
+
+	return OutboxEntry{
+		ID:       e.ID,
+		Owner:    e.Owner,
+		Mailbox:  e.Mailbox,
+		Emails:   emails,
+		Created:  e.Created,
+		Attempts: e.Attempts,
+		Until:    e.Until,
+		Sent:     e.Sent,
+		Notifier: e.Notifier,
+	}
+}
+
+type storedEmail struct {
+	UID          uint32
+	UIDValidity  uint32
+	Account      string
+	MessageID    string
+	Froms        []Address
+	Senders      []Address
+	Subject      string
+	IsRead       bool
+	Mailbox      string
+	SentDate     time.Time
+	RecievedDate time.Time
+	To           []Address
+	Cc           []Address
+	Flags        []string
+	Size         uint32
+
+	Header      textproto.MIMEHeader `json:",omitempty"`
+	Text        string               `json:",omitempty"`
+	HTML        string               `json:",omitempty"`
+	Attachments []Attachment         `json:",omitempty"`
+}
+
+func newStoredEmail(m Email) storedEmail {
+	return storedEmail{
+		UID:          m.uid,
+		UIDValidity:  m.uidValidity,
+		Account:      m.account,
+		MessageID:    m.MessageID,
+		Froms:        m.Froms,
+		Senders:      m.Senders,
+		Subject:      m.Subject,
+		IsRead:       m.read,
+		Mailbox:      m.mailbox,
+		SentDate:     m.SentDate,
+		RecievedDate: m.RecievedDate,
+		To:           m.To,
+		Cc:           m.Cc,
+		Flags:        m.Flags,
+		Size:         m.Size,
+		Header:       m.Header,
+		Text:         m.Text,
+		HTML:         m.HTML,
+		Attachments:  m.Attachments,
+	}
+}
+
+// email restores the email, which is detached from the mail server,
+// so the methods to operate the server, such as SetRead, Move and Raw,
+// must not be called.
+func (e storedEmail) email() Email {
+	return Email{
+		Froms:        e.Froms,
+		Senders:      e.Senders,
+		Subject:      e.Subject,
+		MessageID:    e.MessageID,
+		SentDate:     e.SentDate,
+		RecievedDate: e.RecievedDate,
+		To:           e.To,
+		Cc:           e.Cc,
+		Flags:        e.Flags,
+		Size:         e.Size,
+		Header:       e.Header,
+		Body:         Body{Text: e.Text, HTML: e.HTML, Attachments: e.Attachments},
+
+		uid:     e.UID,
+		read:    e.IsRead,
+		account: e.Account,
+		mailbox: e.Mailbox,
+
+		uidValidity: e.UIDValidity,
+	}
+}
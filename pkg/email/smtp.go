@@ -0,0 +1,215 @@
+// Copyright 2023 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package email
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// Predefine some TLS modes of the SMTP connection.
+const (
+	SMTPTLSNone     = "none"     // Plain connection without TLS.
+	SMTPTLSStartTLS = "starttls" // Upgrade the plain connection by STARTTLS.
+	SMTPTLSImplicit = "tls"      // Connect to the server by TLS directly.
+)
+
+// Predefine some SMTP authentication mechanisms.
+const (
+	SMTPAuthPlain = "plain"
+	SMTPAuthLogin = "login"
+)
+
+// SMTPConfig is the config to connect to the SMTP server.
+type SMTPConfig struct {
+	Addr     string `validate:"required"` // Such as "smtp.example.com:587"
+	Username string // If empty, do not authenticate.
+	Password string
+
+	// Auth is the authentication mechanism, "plain" or "login".
+	//
+	// Default: "plain"
+	Auth string
+
+	// TLS is the TLS mode, "none", "starttls" or "tls".
+	//
+	// Default: "tls" if the port is 465, or "starttls".
+	TLS string
+
+	InsecureSkipVerify bool
+
+	// Timeout is the timeout to send an email if ctx has no deadline.
+	//
+	// Default: 30s
+	Timeout time.Duration
+}
+
+// Check checks whether the config is valid and sets the default values.
+func (c *SMTPConfig) Check() error {
+	_, port, err := net.SplitHostPort(c.Addr)
+	if err != nil {
+		return fmt.Errorf("invalid smtp address '%s': %w", c.Addr, err)
+	}
+
+	switch c.Auth = strings.ToLower(c.Auth); c.Auth {
+	case "":
+		c.Auth = SMTPAuthPlain
+	case SMTPAuthPlain, SMTPAuthLogin:
+	default:
+		return fmt.Errorf("unsupported smtp auth '%s'", c.Auth)
+	}
+
+	switch c.TLS = strings.ToLower(c.TLS); c.TLS {
+	case "":
+		if port == "465" {
+			c.TLS = SMTPTLSImplicit
+		} else {
+			c.TLS = SMTPTLSStartTLS
+		}
+	case SMTPTLSNone, SMTPTLSStartTLS, SMTPTLSImplicit:
+	default:
+		return fmt.Errorf("unsupported smtp tls mode '%s'", c.TLS)
+	}
+
+	if c.Timeout <= 0 {
+		c.Timeout = time.Second * 30
+	}
+
+	return nil
+}
+
+// SendMail connects to the SMTP server and sends the RFC 822 message
+// from the envelope sender to the recipients.
+//
+// config must have been checked by its method Check.
+func SendMail(ctx context.Context, config SMTPConfig, from string, to []string, msg []byte) (err error) {
+	if len(to) == 0 {
+		panic("SendMail: recipients must not be empty")
+	}
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.Timeout)
+		defer cancel()
+	}
+
+	host, _, err := net.SplitHostPort(config.Addr)
+	if err != nil {
+		return
+	}
+
+	tlsconfig := &tls.Config{ServerName: host, InsecureSkipVerify: config.InsecureSkipVerify}
+	conn, err := new(net.Dialer).DialContext(ctx, "tcp", config.Addr)
+	if err != nil {
+		return
+	}
+	if config.TLS == SMTPTLSImplicit {
+		conn = tls.Client(conn, tlsconfig)
+	}
+
+	// Interrupt the blocking reads and writes when ctx is done.
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	defer client.Close()
+
+	if config.TLS == SMTPTLSStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return errors.New("smtp server does not support STARTTLS")
+		}
+		if err = client.StartTLS(tlsconfig); err != nil {
+			return
+		}
+	}
+
+	if config.Username != "" {
+		var auth smtp.Auth
+		if config.Auth == SMTPAuthLogin {
+			auth = loginAuth{username: config.Username, password: config.Password}
+		} else {
+			auth = smtp.PlainAuth("", config.Username, config.Password, host)
+		}
+
+		if err = client.Auth(auth); err != nil {
+			return
+		}
+	}
+
+	if err = client.Mail(from); err != nil {
+		return
+	}
+	for _, addr := range to {
+		if err = client.Rcpt(addr); err != nil {
+			return
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return
+	}
+	if _, err = w.Write(msg); err != nil {
+		w.Close()
+		return
+	}
+	if err = w.Close(); err != nil {
+		return
+	}
+
+	return client.Quit()
+}
+
+// loginAuth implements the non-standard but widely used LOGIN mechanism.
+type loginAuth struct {
+	username string
+	password string
+}
+
+func (a loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, errors.New("unencrypted connection")
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+
+	switch prompt := strings.ToLower(strings.TrimSpace(string(fromServer))); {
+	case strings.HasPrefix(prompt, "username"):
+		return []byte(a.username), nil
+	case strings.HasPrefix(prompt, "password"):
+		return []byte(a.password), nil
+	default:
+		return nil, fmt.Errorf("unexpected server challenge '%s'", fromServer)
+	}
+}
+
+func isLocalhost(name string) bool {
+	return name == "localhost" || name == "127.0.0.1" || name == "::1"
+}
@@ -0,0 +1,175 @@
+// Copyright 2023 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package email
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-imap/backend/memory"
+	"github.com/emersion/go-imap/server"
+	"github.com/xgfone/emailmanager/pkg/internal/smtptest"
+)
+
+func TestSendMail(t *testing.T) {
+	tests := []struct {
+		tls      string
+		auth     string
+		username string
+		expect   string // The expected auth mechanism
+	}{
+		{SMTPTLSStartTLS, SMTPAuthPlain, "user", "PLAIN"},
+		{SMTPTLSStartTLS, SMTPAuthLogin, "user", "LOGIN"},
+		{SMTPTLSImplicit, SMTPAuthPlain, "user", "PLAIN"},
+		{SMTPTLSImplicit, SMTPAuthLogin, "user", "LOGIN"},
+		{SMTPTLSNone, SMTPAuthLogin, "user", "LOGIN"}, // Allowed on localhost.
+		{SMTPTLSNone, "", "", ""},
+	}
+
+	msg := "Subject: test\r\n\r\nhello\r\n.dot\r\n"
+	for _, test := range tests {
+		name := test.tls + "/" + test.auth
+		s := smtptest.NewServer(test.tls, test.username, "pass")
+
+		config := SMTPConfig{
+			Addr:               s.Addr,
+			Username:           test.username,
+			Password:           "pass",
+			Auth:               test.auth,
+			TLS:                test.tls,
+			InsecureSkipVerify: true,
+		}
+		if err := config.Check(); err != nil {
+			t.Fatalf("'%s': %v", name, err)
+		}
+
+		err := SendMail(context.Background(), config, "a@example.com", []string{"b@example.com", "c@example.com"}, []byte(msg))
+		s.Close()
+		if err != nil {
+			t.Errorf("'%s': unexpected error: %v", name, err)
+			continue
+		}
+
+		msgs := s.Messages()
+		if len(msgs) != 1 {
+			t.Errorf("'%s': expect 1 message, but got %d", name, len(msgs))
+			continue
+		}
+
+		m := msgs[0]
+		if m.TLS != (test.tls != SMTPTLSNone) {
+			t.Errorf("'%s': expect tls %v, but got %v", name, !m.TLS, m.TLS)
+		}
+		if m.Auth != test.expect || m.Username != test.username {
+			t.Errorf("'%s': expect auth %s by '%s', but got %s by '%s'",
+				name, test.expect, test.username, m.Auth, m.Username)
+		}
+		if m.From != "a@example.com" || strings.Join(m.To, ",") != "b@example.com,c@example.com" {
+			t.Errorf("'%s': unexpected envelope: from=%s, to=%v", name, m.From, m.To)
+		}
+		if expect := "Subject: test\n\nhello\n.dot\n"; m.Data != expect {
+			t.Errorf("'%s': expect data '%q', but got '%q'", name, expect, m.Data)
+		}
+	}
+}
+
+func TestSendMailError(t *testing.T) {
+	tests := []struct {
+		tls      string
+		password string
+		expect   string
+	}{
+		{SMTPTLSNone, "pass", "does not support STARTTLS"},
+		{SMTPTLSStartTLS, "wrong", "535"},
+	}
+
+	for _, test := range tests {
+		s := smtptest.NewServer(test.tls, "user", "pass")
+
+		config := SMTPConfig{
+			Addr:               s.Addr,
+			Username:           "user",
+			Password:           test.password,
+			TLS:                SMTPTLSStartTLS,
+			InsecureSkipVerify: true,
+		}
+		if err := config.Check(); err != nil {
+			t.Fatal(err)
+		}
+
+		err := SendMail(context.Background(), config, "a@example.com", []string{"b@example.com"}, []byte("\r\n"))
+		s.Close()
+		if err == nil || !strings.Contains(err.Error(), test.expect) {
+			t.Errorf("'%s': expect the error '%s', but got %v", test.tls, test.expect, err)
+		} else if msgs := s.Messages(); len(msgs) != 0 {
+			t.Errorf("'%s': expect no messages, but got %d", test.tls, len(msgs))
+		}
+	}
+}
+
+func TestForwardHandler(t *testing.T) {
+	imapServer := server.New(memory.New())
+	imapServer.AllowInsecureAuth = true
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go imapServer.Serve(listener)
+	defer imapServer.Close()
+
+	smtpServer := smtptest.NewServer(smtptest.TLSStartTLS, "user", "pass")
+	defer smtpServer.Close()
+
+	config := SMTPConfig{
+		Addr:               smtpServer.Addr,
+		Username:           "user",
+		Password:           "pass",
+		InsecureSkipVerify: true,
+	}
+	if err := config.Check(); err != nil {
+		t.Fatal(err)
+	}
+
+	to := []string{"b@example.com", "c@example.com"}
+	forward := ForwardHandler(config, "a@example.com", to, nil)
+	emails, _, err := FetchEmails(context.Background(), listener.Addr().String(),
+		"username", "password", Inbox, nil, 10, forward)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(emails) != 1 {
+		t.Fatalf("expect 1 email, but got %d", len(emails))
+	}
+
+	msgs := smtpServer.Messages()
+	if len(msgs) != 1 {
+		t.Fatalf("expect 1 forwarded message, but got %d", len(msgs))
+	}
+
+	lines := strings.Split(msgs[0].Data, "\n")
+	if len(lines) < 4 ||
+		lines[0] != "Resent-From: a@example.com" ||
+		lines[1] != "Resent-To: b@example.com, c@example.com" ||
+		!strings.HasPrefix(lines[2], "Resent-Date: ") {
+		t.Errorf("unexpected resent header fields: %q", lines[:min(len(lines), 3)])
+	}
+	if !strings.Contains(msgs[0].Data, "\nSubject: "+emails[0].Subject+"\n") {
+		t.Errorf("the original message is not kept: %q", msgs[0].Data)
+	}
+	if msgs[0].From != "a@example.com" || strings.Join(msgs[0].To, ",") != strings.Join(to, ",") {
+		t.Errorf("unexpected envelope: from=%s, to=%v", msgs[0].From, msgs[0].To)
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright 2023 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package email
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// MailboxState represents the fetch state of a mailbox.
+type MailboxState struct {
+	UIDValidity uint32
+	LastUID     uint32
+}
+
+// StateStore is used to store the fetch states of the mailboxes.
+type StateStore interface {
+	// LoadState returns the state of the mailbox belonging to the account,
+	// which may be replaced with the other key, such as the controller id.
+	//
+	// If the state does not exist, return the ZERO value instead.
+	LoadState(account, mailbox string) (MailboxState, error)
+	SaveState(account, mailbox string, state MailboxState) error
+}
+
+type mailboxStates map[string]map[string]MailboxState
+
+func (s mailboxStates) load(account, mailbox string) MailboxState {
+	return s[account][mailbox]
+}
+
+func (s mailboxStates) save(account, mailbox string, state MailboxState) {
+	boxes, ok := s[account]
+	if !ok {
+		boxes = make(map[string]MailboxState, 4)
+		s[account] = boxes
+	}
+	boxes[mailbox] = state
+}
+
+// NewMemoryStateStore returns a new state store based on the memory.
+func NewMemoryStateStore() StateStore {
+	return &memoryStateStore{states: make(mailboxStates, 4)}
+}
+
+type memoryStateStore struct {
+	lock   sync.RWMutex
+	states mailboxStates
+}
+
+func (s *memoryStateStore) LoadState(account, mailbox string) (MailboxState, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.states.load(account, mailbox), nil
+}
+
+func (s *memoryStateStore) SaveState(account, mailbox string, state MailboxState) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.states.save(account, mailbox, state)
+	return nil
+}
+
+// NewFileStateStore returns a new state store based on the json file,
+// which loads the states from the file if it exists.
+func NewFileStateStore(filepath string) (StateStore, error) {
+	if filepath == "" {
+		panic("NewFileStateStore: filepath must not be empty")
+	}
+
+	s := &fileStateStore{filepath: filepath, states: make(mailboxStates, 4)}
+	data, err := os.ReadFile(filepath)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return nil, err
+	case len(data) > 0:
+		if err = json.Unmarshal(data, &s.states); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+type fileStateStore struct {
+	filepath string
+
+	lock   sync.Mutex
+	states mailboxStates
+}
+
+func (s *fileStateStore) LoadState(account, mailbox string) (MailboxState, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.states.load(account, mailbox), nil
+}
+
+func (s *fileStateStore) SaveState(account, mailbox string, state MailboxState) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.states.save(account, mailbox, state)
+	data, err := json.MarshalIndent(s.states, "", "    ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(s.filepath, data)
+}
+
+// writeFileAtomically writes the data into a temporary file in the same
+// directory, then renames it to filename, so that the file is either
+// the old or the new content even if the process crashes.
+func writeFileAtomically(filename string, data []byte) (err error) {
+	file, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".*.tmp")
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(file.Name())
+		}
+	}()
+
+	if _, err = file.Write(data); err != nil {
+		file.Close()
+		return
+	}
+	if err = file.Close(); err != nil {
+		return
+	}
+	return os.Rename(file.Name(), filename)
+}
@@ -0,0 +1,276 @@
+// Copyright 2023 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package smtptest provides a minimal in-process SMTP server for the tests,
+// which supports STARTTLS, implicit TLS and the PLAIN and LOGIN auth.
+package smtptest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"io"
+	"math/big"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Predefine the TLS modes of the server.
+const (
+	TLSNone     = "none"
+	TLSStartTLS = "starttls"
+	TLSImplicit = "tls"
+)
+
+// Message is a message received by the server.
+type Message struct {
+	TLS      bool   // Whether the message is received over TLS.
+	Auth     string // The auth mechanism, "PLAIN" or "LOGIN", or empty.
+	Username string
+	Password string
+
+	From string
+	To   []string
+	Data string // The line endings are converted to "\n".
+}
+
+// Server is a minimal SMTP server listening on the loopback interface.
+type Server struct {
+	Addr string // Such as "127.0.0.1:12345"
+
+	tls       string
+	username  string
+	password  string
+	tlsconfig *tls.Config
+	listener  net.Listener
+
+	lock     sync.Mutex
+	messages []Message
+	wg       sync.WaitGroup
+}
+
+// NewServer starts and returns a new SMTP server with the TLS mode,
+// which requires the authentication if username is not empty.
+//
+// The certificate of the server is self-signed, so the client should skip
+// the verification.
+func NewServer(tlsmode, username, password string) *Server {
+	tlsconfig := &tls.Config{Certificates: []tls.Certificate{newCertificate()}}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	if tlsmode == TLSImplicit {
+		listener = tls.NewListener(listener, tlsconfig)
+	}
+
+	s := &Server{
+		Addr:      listener.Addr().String(),
+		tls:       tlsmode,
+		username:  username,
+		password:  password,
+		tlsconfig: tlsconfig,
+		listener:  listener,
+	}
+
+	s.wg.Add(1)
+	go s.accept()
+	return s
+}
+
+// Close stops the server and waits for the connections to finish.
+func (s *Server) Close() {
+	s.listener.Close()
+	s.wg.Wait()
+}
+
+// Messages returns all the messages received by the server.
+func (s *Server) Messages() []Message {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]Message(nil), s.messages...)
+}
+
+func (s *Server) accept() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.serve(conn)
+		}()
+	}
+}
+
+func (s *Server) serve(conn net.Conn) {
+	defer func() { conn.Close() }()
+	conn.SetDeadline(time.Now().Add(time.Second * 10))
+
+	var msg Message
+	_, msg.TLS = conn.(*tls.Conn)
+	text := textproto.NewConn(conn)
+	text.PrintfLine("220 localhost ESMTP")
+
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+
+		cmd, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(cmd) {
+		case "EHLO", "HELO":
+			exts := []string{"localhost"}
+			if s.tls == TLSStartTLS && !msg.TLS {
+				exts = append(exts, "STARTTLS")
+			}
+			if s.username != "" {
+				exts = append(exts, "AUTH PLAIN LOGIN")
+			}
+			for i, ext := range exts {
+				if i == len(exts)-1 {
+					text.PrintfLine("250 %s", ext)
+				} else {
+					text.PrintfLine("250-%s", ext)
+				}
+			}
+
+		case "STARTTLS":
+			if s.tls != TLSStartTLS || msg.TLS {
+				text.PrintfLine("502 STARTTLS not supported")
+				continue
+			}
+
+			text.PrintfLine("220 ready to start TLS")
+			conn = tls.Server(conn, s.tlsconfig)
+			text = textproto.NewConn(conn)
+			msg.TLS = true
+
+		case "AUTH":
+			msg.Auth, msg.Username, msg.Password = s.auth(text, arg)
+			if s.username != "" && msg.Username == s.username && msg.Password == s.password {
+				text.PrintfLine("235 authentication succeeded")
+			} else {
+				text.PrintfLine("535 authentication failed")
+			}
+
+		case "MAIL":
+			msg.From = parsePath(arg)
+			text.PrintfLine("250 ok")
+
+		case "RCPT":
+			msg.To = append(msg.To, parsePath(arg))
+			text.PrintfLine("250 ok")
+
+		case "DATA":
+			if s.username != "" && msg.Auth == "" {
+				text.PrintfLine("530 authentication required")
+				continue
+			}
+
+			text.PrintfLine("354 end data with <CR><LF>.<CR><LF>")
+			data, err := io.ReadAll(text.DotReader())
+			if err != nil {
+				return
+			}
+
+			msg.Data = string(data)
+			s.lock.Lock()
+			s.messages = append(s.messages, msg)
+			s.lock.Unlock()
+
+			msg.From, msg.To, msg.Data = "", nil, ""
+			text.PrintfLine("250 ok")
+
+		case "RSET", "NOOP":
+			text.PrintfLine("250 ok")
+
+		case "QUIT":
+			text.PrintfLine("221 bye")
+			return
+
+		default:
+			text.PrintfLine("502 command not implemented")
+		}
+	}
+}
+
+func (s *Server) auth(text *textproto.Conn, arg string) (mechanism, username, password string) {
+	mechanism, resp, _ := strings.Cut(arg, " ")
+	switch mechanism = strings.ToUpper(mechanism); mechanism {
+	case "PLAIN":
+		data, _ := base64.StdEncoding.DecodeString(resp)
+		if fields := strings.Split(string(data), "\x00"); len(fields) == 3 {
+			username, password = fields[1], fields[2]
+		}
+
+	case "LOGIN":
+		username = challenge(text, "Username:")
+		password = challenge(text, "Password:")
+	}
+	return
+}
+
+func challenge(text *textproto.Conn, prompt string) string {
+	text.PrintfLine("334 %s", base64.StdEncoding.EncodeToString([]byte(prompt)))
+	line, _ := text.ReadLine()
+	data, _ := base64.StdEncoding.DecodeString(line)
+	return string(data)
+}
+
+// parsePath returns the address of the argument "FROM:<addr> ..." or "TO:<addr>".
+func parsePath(arg string) string {
+	if start := strings.IndexByte(arg, '<'); start > -1 {
+		if end := strings.IndexByte(arg[start:], '>'); end > -1 {
+			return arg[start+1 : start+end]
+		}
+	}
+	return ""
+}
+
+func newCertificate() tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		panic(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
@@ -0,0 +1,285 @@
+// Copyright 2023 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package metrics provides some simple metrics with the labels,
+// which are exposed in the Prometheus text format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefBuckets is the default buckets of the histogram, in seconds.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60}
+
+var (
+	lock    sync.RWMutex
+	metrics []*metric
+)
+
+func register(m *metric) *metric {
+	lock.Lock()
+	defer lock.Unlock()
+
+	for _, _m := range metrics {
+		if _m.name == m.name {
+			panic(fmt.Errorf("metric '%s' has been registered", m.name))
+		}
+	}
+
+	metrics = append(metrics, m)
+	return m
+}
+
+// WriteTo writes all the registered metrics into w in the Prometheus text format.
+func WriteTo(w io.Writer) error {
+	lock.RLock()
+	_metrics := slices.Clone(metrics)
+	lock.RUnlock()
+
+	sort.Slice(_metrics, func(i, j int) bool { return _metrics[i].name < _metrics[j].name })
+
+	buf := bufio.NewWriter(w)
+	for _, m := range _metrics {
+		m.writeTo(buf)
+	}
+	return buf.Flush()
+}
+
+// Handler returns a http handler to expose all the registered metrics.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		WriteTo(w)
+	})
+}
+
+// Counter is a monotonically increasing metric with the labels.
+type Counter struct{ m *metric }
+
+// NewCounter returns and registers a new counter.
+func NewCounter(name, help string, labels ...string) Counter {
+	return Counter{register(newMetric("counter", name, help, labels, nil))}
+}
+
+// Inc increases the counter with the label values by 1.
+func (c Counter) Inc(values ...string) { c.Add(1, values...) }
+
+// Add increases the counter with the label values by v, which must not be negative.
+func (c Counter) Add(v float64, values ...string) {
+	if v < 0 {
+		panic("Counter.Add: the value must not be negative")
+	}
+	c.m.update(values, func(s *series) { s.value += v })
+}
+
+// DeleteLabel deletes all the series whose label has the value.
+func (c Counter) DeleteLabel(label, value string) { c.m.deleteLabel(label, value) }
+
+// Gauge is a metric with the labels, which can be set to any value.
+type Gauge struct{ m *metric }
+
+// NewGauge returns and registers a new gauge.
+func NewGauge(name, help string, labels ...string) Gauge {
+	return Gauge{register(newMetric("gauge", name, help, labels, nil))}
+}
+
+// Set sets the gauge with the label values to v.
+func (g Gauge) Set(v float64, values ...string) {
+	g.m.update(values, func(s *series) { s.value = v })
+}
+
+// DeleteLabel deletes all the series whose label has the value.
+func (g Gauge) DeleteLabel(label, value string) { g.m.deleteLabel(label, value) }
+
+// Histogram is a metric with the labels, which counts the observed values
+// in the configurable buckets.
+type Histogram struct{ m *metric }
+
+// NewHistogram returns and registers a new histogram.
+//
+// If buckets is empty, use DefBuckets instead.
+func NewHistogram(name, help string, buckets []float64, labels ...string) Histogram {
+	if len(buckets) == 0 {
+		buckets = DefBuckets
+	}
+	buckets = slices.Clone(buckets)
+	slices.Sort(buckets)
+	return Histogram{register(newMetric("histogram", name, help, labels, buckets))}
+}
+
+// Observe adds the value v to the histogram with the label values.
+func (h Histogram) Observe(v float64, values ...string) {
+	h.m.update(values, func(s *series) {
+		if s.counts == nil {
+			s.counts = make([]uint64, len(h.m.buckets))
+		}
+		if index := sort.SearchFloat64s(h.m.buckets, v); index < len(s.counts) {
+			s.counts[index]++
+		}
+		s.value += v
+		s.count++
+	})
+}
+
+// DeleteLabel deletes all the series whose label has the value.
+func (h Histogram) DeleteLabel(label, value string) { h.m.deleteLabel(label, value) }
+
+type series struct {
+	values []string
+	value  float64 // The value of counter or gauge, or the sum of histogram.
+
+	// Only for histogram
+	count  uint64
+	counts []uint64 // The non-cumulative counts of the buckets.
+}
+
+type metric struct {
+	typ     string
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+
+	lock   sync.Mutex
+	series map[string]*series
+}
+
+func newMetric(typ, name, help string, labels []string, buckets []float64) *metric {
+	return &metric{
+		typ:     typ,
+		name:    name,
+		help:    help,
+		labels:  labels,
+		buckets: buckets,
+		series:  make(map[string]*series, 4),
+	}
+}
+
+func (m *metric) update(values []string, update func(*series)) {
+	if len(values) != len(m.labels) {
+		panic(fmt.Errorf("metric '%s' expects %d label values, but got %d",
+			m.name, len(m.labels), len(values)))
+	}
+
+	key := strings.Join(values, "\xff")
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	s, ok := m.series[key]
+	if !ok {
+		s = &series{values: slices.Clone(values)}
+		m.series[key] = s
+	}
+	update(s)
+}
+
+func (m *metric) deleteLabel(label, value string) {
+	index := slices.Index(m.labels, label)
+	if index < 0 {
+		panic(fmt.Errorf("metric '%s' has no label '%s'", m.name, label))
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for key, s := range m.series {
+		if s.values[index] == value {
+			delete(m.series, key)
+		}
+	}
+}
+
+func (m *metric) writeTo(w *bufio.Writer) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	keys := make([]string, 0, len(m.series))
+	for key := range m.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	fmt.Fprintf(w, "# HELP %s %s\n", m.name, escapeHelp(m.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", m.name, m.typ)
+	for _, key := range keys {
+		s := m.series[key]
+		if m.typ != "histogram" {
+			writeSample(w, m.name, m.labels, s.values, "", s.value)
+			continue
+		}
+
+		var cumulative uint64
+		for i, bucket := range m.buckets {
+			if s.counts != nil {
+				cumulative += s.counts[i]
+			}
+			writeSample(w, m.name+"_bucket", m.labels, s.values, formatFloat(bucket), float64(cumulative))
+		}
+		writeSample(w, m.name+"_bucket", m.labels, s.values, "+Inf", float64(s.count))
+		writeSample(w, m.name+"_sum", m.labels, s.values, "", s.value)
+		writeSample(w, m.name+"_count", m.labels, s.values, "", float64(s.count))
+	}
+}
+
+func writeSample(w *bufio.Writer, name string, labels, values []string, le string, value float64) {
+	w.WriteString(name)
+	if len(labels) > 0 || le != "" {
+		w.WriteByte('{')
+		for i, label := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, `%s="%s"`, label, escapeLabel(values[i]))
+		}
+		if le != "" {
+			if len(labels) > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, `le="%s"`, le)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(value))
+	w.WriteByte('\n')
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2023 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+)
+
+func writeMetric(m *metric) string {
+	var b strings.Builder
+	w := bufio.NewWriter(&b)
+	m.writeTo(w)
+	w.Flush()
+	return b.String()
+}
+
+func TestCounter(t *testing.T) {
+	c := NewCounter("test_counter_total", "The test\ncounter.", "controller", "handler")
+	c.Inc("b", "h1")
+	c.Add(2.5, "a", `h"2\`)
+	c.Inc("a", `h"2\`)
+
+	expect := `# HELP test_counter_total The test\ncounter.
+# TYPE test_counter_total counter
+test_counter_total{controller="a",handler="h\"2\\"} 3.5
+test_counter_total{controller="b",handler="h1"} 1
+`
+	if s := writeMetric(c.m); s != expect {
+		t.Errorf("expect:\n%s\nbut got:\n%s", expect, s)
+	}
+
+	c.DeleteLabel("controller", "a")
+	expect = `# HELP test_counter_total The test\ncounter.
+# TYPE test_counter_total counter
+test_counter_total{controller="b",handler="h1"} 1
+`
+	if s := writeMetric(c.m); s != expect {
+		t.Errorf("expect:\n%s\nbut got:\n%s", expect, s)
+	}
+}
+
+func TestGauge(t *testing.T) {
+	g := NewGauge("test_gauge", "The test gauge.")
+	g.Set(3)
+	g.Set(1.5)
+
+	expect := `# HELP test_gauge The test gauge.
+# TYPE test_gauge gauge
+test_gauge 1.5
+`
+	if s := writeMetric(g.m); s != expect {
+		t.Errorf("expect:\n%s\nbut got:\n%s", expect, s)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram("test_duration_seconds", "The test histogram.", []float64{1, 0.1, 0.5}, "controller")
+	for _, v := range []float64{0.05, 0.1, 0.3, 0.7, 2} {
+		h.Observe(v, "a")
+	}
+	h.Observe(0.5, "b")
+
+	// The buckets are sorted, the upper bounds are inclusive,
+	// and the counts are cumulative.
+	expect := `# HELP test_duration_seconds The test histogram.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{controller="a",le="0.1"} 2
+test_duration_seconds_bucket{controller="a",le="0.5"} 3
+test_duration_seconds_bucket{controller="a",le="1"} 4
+test_duration_seconds_bucket{controller="a",le="+Inf"} 5
+test_duration_seconds_sum{controller="a"} 3.15
+test_duration_seconds_count{controller="a"} 5
+test_duration_seconds_bucket{controller="b",le="0.1"} 0
+test_duration_seconds_bucket{controller="b",le="0.5"} 1
+test_duration_seconds_bucket{controller="b",le="1"} 1
+test_duration_seconds_bucket{controller="b",le="+Inf"} 1
+test_duration_seconds_sum{controller="b"} 0.5
+test_duration_seconds_count{controller="b"} 1
+`
+	if s := writeMetric(h.m); s != expect {
+		t.Errorf("expect:\n%s\nbut got:\n%s", expect, s)
+	}
+
+	h.DeleteLabel("controller", "a")
+	h.DeleteLabel("controller", "b")
+	expect = `# HELP test_duration_seconds The test histogram.
+# TYPE test_duration_seconds histogram
+`
+	if s := writeMetric(h.m); s != expect {
+		t.Errorf("expect:\n%s\nbut got:\n%s", expect, s)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	NewGauge("test_register", "")
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expect a panic for the duplicate metric")
+		}
+	}()
+	NewCounter("test_register", "")
+}
+
+func TestLabelValues(t *testing.T) {
+	c := NewCounter("test_label_values_total", "", "controller")
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expect a panic for the mismatched label values")
+		}
+	}()
+	c.Inc("a", "b")
+}
@@ -0,0 +1,207 @@
+// Copyright 2023 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package dingtalk provides a function to send the message notice by dingtalk robot.
+package dingtalk
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/xgfone/emailmanager/pkg/notice"
+	"github.com/xgfone/go-binder"
+	"github.com/xgfone/go-structs"
+)
+
+const urlprefix = "https://oapi.dingtalk.com/robot/send"
+
+// Predefine some message types.
+const (
+	MsgTypeText     = "text"
+	MsgTypeMarkdown = "markdown"
+)
+
+func init() {
+	notice.RegisterNotifierBuilder("dingtalkwebhook", func(configs map[string]interface{}) (notice.Notifier, error) {
+		var config WebhookConfig
+		if err := binder.BindStructToMap(&config, "json", configs); err != nil {
+			return nil, err
+		}
+		if err := structs.Reflect(config); err != nil {
+			return nil, err
+		}
+		return NewWebhookNotifier(config)
+	})
+}
+
+// WebhookConfig is the dingtalk robot webhook config.
+type WebhookConfig struct {
+	AccessToken string `validate:"required"`
+	Secret      string // If set, sign the request.
+	MsgType     string // "text" or "markdown". Default: "text"
+
+	AtMobiles []string
+	AtUserIDs []string `json:"AtUserIds"`
+	IsAtAll   bool
+}
+
+// NewWebhookNotifier returns a notifier based on dingtalk robot webhook.
+func NewWebhookNotifier(config WebhookConfig) (notice.Notifier, error) {
+	if config.AccessToken == "" {
+		panic("NewWebhookNotifier: access token must not be empty")
+	}
+
+	switch config.MsgType {
+	case "":
+		config.MsgType = MsgTypeText
+	case MsgTypeText, MsgTypeMarkdown:
+	default:
+		return nil, fmt.Errorf("unsupported dingtalk message type '%s'", config.MsgType)
+	}
+
+	desc := fmt.Sprintf("DingTalkWebhook(msgtype=%s)", config.MsgType)
+	return notice.NewNotifier(desc, func(ctx context.Context, emails ...notice.Email) error {
+		return SendWebhook(ctx, config, emails...)
+	}), nil
+}
+
+// SendWebhook sends the webhook message notice.
+func SendWebhook(ctx context.Context, config WebhookConfig, emails ...notice.Email) (err error) {
+	if len(emails) == 0 {
+		return
+	}
+
+	query := url.Values{"access_token": []string{config.AccessToken}}
+	if config.Secret != "" {
+		timestamp := fmt.Sprint(time.Now().UnixMilli())
+		signature, err := genDingTalkSign(config.Secret, timestamp)
+		if err != nil {
+			return err
+		}
+
+		query.Set("timestamp", timestamp)
+		query.Set("sign", signature)
+	}
+
+	// DingTalk only highlights the mentioned users who appear in the text.
+	mentions := make([]string, 0, len(config.AtMobiles)+len(config.AtUserIDs))
+	for _, mobile := range config.AtMobiles {
+		mentions = append(mentions, "@"+mobile)
+	}
+	for _, userid := range config.AtUserIDs {
+		mentions = append(mentions, "@"+userid)
+	}
+
+	title := notice.Title(ctx, fmt.Sprintf("您有%d封未读邮件", len(emails)))
+	msg := map[string]interface{}{
+		"msgtype": config.MsgType,
+		"at": map[string]interface{}{
+			"atMobiles": config.AtMobiles,
+			"atUserIds": config.AtUserIDs,
+			"isAtAll":   config.IsAtAll,
+		},
+	}
+
+	switch config.MsgType {
+	case MsgTypeMarkdown:
+		contents := make([]string, 1, len(emails)+2)
+		contents[0] = fmt.Sprintf("#### %s:", title)
+		for i, email := range emails {
+			if i > 10 {
+				contents = append(contents, "......")
+				break
+			}
+			contents = append(contents, fmt.Sprintf("%d. [%s] **%s** (%s)", i+1,
+				email.Mailbox(), email.Subject, email.Sender()))
+		}
+		if len(mentions) > 0 {
+			contents = append(contents, strings.Join(mentions, " "))
+		}
+		msg["markdown"] = map[string]interface{}{
+			"title": title,
+			"text":  strings.Join(contents, "\n\n"),
+		}
+
+	default:
+		contents := make([]string, 1, len(emails)+2)
+		contents[0] = title + ":"
+		for i, email := range emails {
+			if i > 10 {
+				contents = append(contents, "......")
+				break
+			}
+			contents = append(contents, fmt.Sprintf("%d. [%s] %s(%s)", i+1,
+				email.Mailbox(), email.Subject, email.Sender()))
+		}
+		if len(mentions) > 0 {
+			contents = append(contents, strings.Join(mentions, " "))
+		}
+		msg["text"] = map[string]interface{}{"content": strings.Join(contents, "\n")}
+	}
+
+	body := bytes.NewBuffer(make([]byte, 0, 512))
+	if err = json.NewEncoder(body).Encode(msg); err != nil {
+		return
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, urlprefix+"?"+query.Encode(), body)
+	if err != nil {
+		return
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		ErrCode int    `json:"errcode"`
+		ErrMsg  string `json:"errmsg"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return
+	} else if result.ErrCode != 0 {
+		err = fmt.Errorf("errcode=%d, errmsg=%s", result.ErrCode, result.ErrMsg)
+		if !retryableErrCodes[result.ErrCode] {
+			err = notice.PermanentError(err)
+		}
+	}
+
+	return
+}
+
+// retryableErrCodes are the transient errcodes, that's, the system is busy
+// and sending too fast. The others, such as the invalid token, signature
+// or keywords, are permanent.
+var retryableErrCodes = map[int]bool{-1: true, 130101: true}
+
+func genDingTalkSign(secret, timestamp string) (string, error) {
+	h := hmac.New(sha256.New, []byte(secret))
+	if _, err := fmt.Fprintf(h, "%s\n%s", timestamp, secret); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
+}
@@ -23,6 +23,7 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"strings"
 	"time"
@@ -32,7 +33,10 @@ import (
 	"github.com/xgfone/go-structs"
 )
 
-const urlprefix = "https://open.feishu.cn/open-apis/bot/v2/hook/"
+const (
+	urlprefix  = "https://open.feishu.cn/open-apis/bot/v2/hook/"
+	previewLen = 50
+)
 
 func init() {
 	notice.RegisterNotifierBuilder("feishuwebhook", func(configs map[string]interface{}) (notice.Notifier, error) {
@@ -78,13 +82,16 @@ func SendWebhook(ctx context.Context, groupID, secret string, emails ...notice.E
 	}
 
 	contents := make([]string, 1, len(emails)+1)
-	contents[0] = fmt.Sprintf("您有%d封未读邮件:", len(emails))
+	contents[0] = notice.Title(ctx, fmt.Sprintf("您有%d封未读邮件:", len(emails)))
 	for i, email := range emails {
 		if i > 10 {
 			contents = append(contents, "......")
 			break
 		}
-		contents = append(contents, fmt.Sprintf("%d. %s(%s)", i+1, email.Subject, email.Sender()))
+		contents = append(contents, fmt.Sprintf("%d. [%s] %s(%s)", i+1, email.Mailbox(), email.Subject, email.Sender()))
+		if preview := email.Preview(previewLen); preview != "" {
+			contents = append(contents, "    "+preview)
+		}
 	}
 	content := strings.Join(contents, "\n")
 
@@ -111,6 +118,11 @@ func SendWebhook(ctx context.Context, groupID, secret string, emails ...notice.E
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		data, _ := io.ReadAll(resp.Body)
+		return notice.NewStatusError(resp.StatusCode, data)
+	}
+
 	var result struct {
 		Code int    `json:"code"`
 		Msg  string `json:"msg"`
@@ -119,12 +131,20 @@ func SendWebhook(ctx context.Context, groupID, secret string, emails ...notice.E
 	if err != nil {
 		return
 	} else if result.Code != 0 {
-		return fmt.Errorf("code=%d, msg=%s", result.Code, result.Msg)
+		err = fmt.Errorf("code=%d, msg=%s", result.Code, result.Msg)
+		if !retryableCodes[result.Code] {
+			err = notice.PermanentError(err)
+		}
 	}
 
 	return
 }
 
+// retryableCodes are the transient codes, that's, the request frequency
+// is limited. The others, such as the invalid signature or keywords,
+// are permanent.
+var retryableCodes = map[int]bool{11232: true}
+
 func genFeishuSign(secret, timestamp string) (string, error) {
 	h := hmac.New(sha256.New, []byte(fmt.Sprintf("%s\n%s", timestamp, secret)))
 	if _, err := h.Write(nil); err != nil {
@@ -17,6 +17,9 @@ package notice
 
 import (
 	"context"
+	htmltemplate "html/template"
+	"io"
+	"text/template"
 
 	"github.com/xgfone/emailmanager/pkg/email"
 )
@@ -24,6 +27,66 @@ import (
 // Email represents an email message.
 type Email = email.Email
 
+type controllerKey struct{}
+
+// WithController returns a new context carrying the name of the controller
+// which sends the notice, so that the notifier can show it.
+func WithController(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, controllerKey{}, name)
+}
+
+// ControllerFromContext returns the name of the controller carried by ctx.
+//
+// If not exist, return "".
+func ControllerFromContext(ctx context.Context) string {
+	name, _ := ctx.Value(controllerKey{}).(string)
+	return name
+}
+
+// Title prefixes the title with the name of the controller carried by ctx
+// as "[name] title", so that the receiver can tell which controller sends it.
+func Title(ctx context.Context, title string) string {
+	if name := ControllerFromContext(ctx); name != "" {
+		return "[" + name + "] " + title
+	}
+	return title
+}
+
+// TemplateFuncs is the common functions of the notice templates,
+// which must be added before parsing the template to be executed
+// by ExecuteTemplate or ExecuteHTMLTemplate.
+//
+//	controller: return the name of the controller sending the notice.
+var TemplateFuncs = template.FuncMap{
+	"controller": func() string { return "" },
+}
+
+// ExecuteTemplate executes the template parsed with TemplateFuncs,
+// whose functions are bound to ctx, and writes the output into w.
+func ExecuteTemplate(ctx context.Context, w io.Writer, tmpl *template.Template, data interface{}) error {
+	tmpl, err := tmpl.Clone()
+	if err != nil {
+		return err
+	}
+
+	name := ControllerFromContext(ctx)
+	tmpl.Funcs(template.FuncMap{"controller": func() string { return name }})
+	return tmpl.Execute(w, data)
+}
+
+// ExecuteHTMLTemplate is the same as ExecuteTemplate, but executes
+// the html template, which escapes the data contextually.
+func ExecuteHTMLTemplate(ctx context.Context, w io.Writer, tmpl *htmltemplate.Template, data interface{}) error {
+	tmpl, err := tmpl.Clone()
+	if err != nil {
+		return err
+	}
+
+	name := ControllerFromContext(ctx)
+	tmpl.Funcs(htmltemplate.FuncMap{"controller": func() string { return name }})
+	return tmpl.Execute(w, data)
+}
+
 // Notifier is a notifier to notice someone.
 type Notifier interface {
 	Notify(ctx context.Context, emails ...Email) error
@@ -42,3 +105,32 @@ type notifier struct {
 
 func (n notifier) Notify(c context.Context, e ...Email) error { return n.send(c, e...) }
 func (n notifier) String() string                             { return n.desc }
+
+// Named is an optional interface implemented by the notifier, whose name
+// identifies it in the metrics. Unlike String, the name must not contain
+// the secrets, such as the token in the webhook url.
+type Named interface {
+	Name() string
+}
+
+// WithName returns a new notifier wrapping the notifier with the name.
+func WithName(notifier Notifier, name string) Notifier {
+	return namedNotifier{Notifier: notifier, name: name}
+}
+
+// NameOf returns the name of the notifier if it implements Named.
+//
+// If not, return "unknown".
+func NameOf(notifier Notifier) string {
+	if n, ok := notifier.(Named); ok {
+		return n.Name()
+	}
+	return "unknown"
+}
+
+type namedNotifier struct {
+	Notifier
+	name string
+}
+
+func (n namedNotifier) Name() string { return n.name }
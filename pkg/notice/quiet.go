@@ -0,0 +1,205 @@
+// Copyright 2023 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package notice
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/xgfone/emailmanager/pkg/daily"
+	"github.com/xgfone/emailmanager/pkg/email"
+)
+
+// HeldError is returned by the notifier which holds the emails to be sent
+// later, such as in the quiet hours, which is not a failure. The caller
+// should keep the held emails and send them again after Until.
+type HeldError struct {
+	Until  time.Time
+	Emails []Email // The held emails, which may be a part of the emails to be sent.
+}
+
+// Error implements the interface error.
+func (e *HeldError) Error() string {
+	return fmt.Sprintf("%d emails are held until %s", len(e.Emails), e.Until.Format(time.RFC3339))
+}
+
+// QuietWindow is a daily window of the quiet hours.
+type QuietWindow struct {
+	// Start and End are the different times of the day, such as "22:00"
+	// and "08:00", and the window crosses midnight if End is before Start.
+	Start string `validate:"required"`
+	End   string `validate:"required"`
+
+	// Weekdays are the days when the window starts, such as "Mon-Fri",
+	// "Fri-Mon" or "Sat", and the window applies to all the days if empty.
+	Weekdays []string
+}
+
+// QuietConfig is the config of the quiet hours of a notifier.
+type QuietConfig struct {
+	Windows []QuietWindow
+
+	// Holidays are the whole days of the quiet hours, such as "2023-10-01".
+	Holidays []string
+
+	// Timezone is the IANA name of the location of the windows and holidays,
+	// such as "Asia/Shanghai". Default: the local timezone.
+	Timezone string
+
+	// Bypass is the expression compiled by email.CompileMatcher,
+	// and the matched emails are sent immediately even in the quiet hours.
+	Bypass string
+}
+
+type quietHours struct {
+	loc      *time.Location
+	windows  []daily.Window
+	holidays map[string]struct{}
+}
+
+// until reports whether t is in the quiet hours, and returns when they end.
+func (q quietHours) until(t time.Time) (end time.Time, quiet bool) {
+	end = t.In(q.loc)
+
+	// The consecutive windows and holidays are merged, but limit
+	// the iterations in case that the whole week is quiet.
+	for i := 0; i < 64; i++ {
+		next, ok := q.activeEnd(end)
+		if !ok {
+			break
+		}
+		end, quiet = next, true
+	}
+	return
+}
+
+func (q quietHours) activeEnd(t time.Time) (end time.Time, ok bool) {
+	if _, ok = q.holidays[t.Format(time.DateOnly)]; ok {
+		end = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		return
+	}
+
+	for _, w := range q.windows {
+		if end, ok = w.Active(t); ok {
+			return
+		}
+	}
+	return
+}
+
+// NewQuietNotifier returns a new notifier wrapping the notifier, which holds
+// the emails in the quiet hours by returning HeldError, and the emails
+// matching the bypass expression are sent immediately even in the quiet hours.
+//
+// The held emails are kept by the caller, such as the controller, which
+// keeps them in the outbox and sends them by this notifier only, as a single
+// digest after the quiet hours end, so that they are not lost even if
+// the process restarts, and the other notifiers are not affected.
+func NewQuietNotifier(notifier Notifier, config QuietConfig) (Notifier, error) {
+	if notifier == nil {
+		panic("NewQuietNotifier: notifier must not be nil")
+	}
+
+	hours, err := parseQuietHours(config)
+	if err != nil {
+		return nil, err
+	}
+
+	var bypass email.Matcher
+	if config.Bypass != "" {
+		if bypass, err = email.CompileMatcher(config.Bypass); err != nil {
+			return nil, fmt.Errorf("invalid bypass expression: %w", err)
+		}
+	}
+
+	n := quietNotifier{notifier: notifier, hours: hours, bypass: bypass}
+	desc := fmt.Sprintf("Quiet(notifier=%s, windows=%d, holidays=%d)",
+		notifier.String(), len(config.Windows), len(config.Holidays))
+	return NewNotifier(desc, n.notify), nil
+}
+
+type quietNotifier struct {
+	notifier Notifier
+	hours    quietHours
+	bypass   email.Matcher
+}
+
+func (n quietNotifier) notify(ctx context.Context, emails ...Email) error {
+	end, quiet := n.hours.until(time.Now())
+	if !quiet {
+		return n.notifier.Notify(ctx, emails...)
+	}
+
+	var sends, holds []Email
+	for i := range emails {
+		if n.bypass != nil && n.bypass(&emails[i]) {
+			sends = append(sends, emails[i])
+		} else {
+			holds = append(holds, emails[i])
+		}
+	}
+
+	if len(sends) > 0 {
+		if err := n.notifier.Notify(ctx, sends...); err != nil {
+			return err
+		}
+	}
+
+	if len(holds) > 0 {
+		slog.Info("hold the emails in the quiet hours", "notifier", n.notifier.String(),
+			"emails", len(holds), "until", end)
+		return &HeldError{Until: end, Emails: holds}
+	}
+	return nil
+}
+
+func parseQuietHours(config QuietConfig) (hours quietHours, err error) {
+	hours.loc = time.Local
+	if config.Timezone != "" {
+		if hours.loc, err = time.LoadLocation(config.Timezone); err != nil {
+			return
+		}
+	}
+
+	hours.holidays = make(map[string]struct{}, len(config.Holidays))
+	for _, day := range config.Holidays {
+		if _, err = time.Parse(time.DateOnly, day); err != nil {
+			return hours, fmt.Errorf("invalid holiday '%s'", day)
+		}
+		hours.holidays[day] = struct{}{}
+	}
+
+	hours.windows = make([]daily.Window, len(config.Windows))
+	for i, w := range config.Windows {
+		if hours.windows[i], err = parseQuietWindow(w); err != nil {
+			return hours, fmt.Errorf("invalid quiet window #%d: %w", i, err)
+		}
+	}
+
+	return
+}
+
+func parseQuietWindow(w QuietWindow) (window daily.Window, err error) {
+	if window, err = daily.NewWindow(w.Start, w.End); err != nil {
+		return
+	}
+	if len(w.Weekdays) > 0 {
+		window.Days, err = daily.ParseWeekdays(strings.Join(w.Weekdays, ","))
+	}
+	return
+}
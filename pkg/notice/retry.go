@@ -0,0 +1,195 @@
+// Copyright 2023 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package notice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// StatusError represents the error that the server responds
+// an unexpected http status code.
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+// NewStatusError returns a new StatusError.
+func NewStatusError(statusCode int, body []byte) StatusError {
+	return StatusError{StatusCode: statusCode, Body: string(body)}
+}
+
+// Error implements the interface error.
+func (e StatusError) Error() string {
+	return fmt.Sprintf("status=%d, body=%s", e.StatusCode, e.Body)
+}
+
+// Retryable reports whether the request may succeed by retrying it,
+// that's, the status code is 408, 429 or 5xx.
+func (e StatusError) Retryable() bool {
+	switch {
+	case e.StatusCode == http.StatusRequestTimeout,
+		e.StatusCode == http.StatusTooManyRequests,
+		e.StatusCode >= 500:
+		return true
+	default:
+		return false
+	}
+}
+
+// permanentError is the error which should not be retried.
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string   { return e.err.Error() }
+func (e permanentError) Unwrap() error   { return e.err }
+func (e permanentError) Retryable() bool { return false }
+
+// PermanentError wraps the error to mark it not to be retried.
+func PermanentError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err: err}
+}
+
+// IsRetryable reports whether the error returned by a notifier is retryable.
+//
+// If the error, or any error in its chain, has the method "Retryable() bool",
+// use it to decide. Or, the error is considered as temporary and retryable,
+// such as the network error or the unexpected response of the server.
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var r interface{ Retryable() bool }
+	if errors.As(err, &r) {
+		return r.Retryable()
+	}
+	return true
+}
+
+// RetryConfig is the config to retry to send the notice.
+type RetryConfig struct {
+	// MaxAttempts is the maximum number of the attempts, including the first.
+	//
+	// Default: 3
+	MaxAttempts int
+
+	// InitialInterval is the backoff interval before the first retry,
+	// which is multiplied by Multiplier for each subsequent retry
+	// up to MaxInterval.
+	//
+	// Default: 1s, 30s, 2
+	InitialInterval time.Duration
+	MaxInterval     time.Duration
+	Multiplier      float64
+
+	// Jitter is the randomization factor in [0, 1], and the actual interval
+	// is randomly chosen in [interval*(1-Jitter), interval*(1+Jitter)].
+	//
+	// Default: 0.2. If negative, disable it.
+	Jitter float64
+
+	// MaxElapsedTime is the maximum time since the first attempt, after which
+	// no retry is performed. If 0, it is only limited by the context.
+	MaxElapsedTime time.Duration
+}
+
+func (c *RetryConfig) setDefaults() {
+	if c.MaxAttempts <= 0 {
+		c.MaxAttempts = 3
+	}
+	if c.InitialInterval <= 0 {
+		c.InitialInterval = time.Second
+	}
+	if c.MaxInterval <= 0 {
+		c.MaxInterval = time.Second * 30
+	}
+	if c.Multiplier < 1 {
+		c.Multiplier = 2
+	}
+	switch {
+	case c.Jitter == 0:
+		c.Jitter = 0.2
+	case c.Jitter < 0:
+		c.Jitter = 0
+	case c.Jitter > 1:
+		c.Jitter = 1
+	}
+}
+
+// backoff returns the backoff interval before the nth retry, starting with 1.
+func (c RetryConfig) backoff(n int) time.Duration {
+	interval := float64(c.InitialInterval)
+	for i := 1; i < n && interval < float64(c.MaxInterval); i++ {
+		interval *= c.Multiplier
+	}
+	if interval > float64(c.MaxInterval) {
+		interval = float64(c.MaxInterval)
+	}
+
+	if c.Jitter > 0 {
+		interval += interval * c.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(interval)
+}
+
+// NewRetryNotifier returns a new notifier wrapping the notifier,
+// which retries to send the notice with the exponential backoff and jitter
+// if the error is retryable, until it succeeds, the attempts are exhausted,
+// the max elapsed time is exceeded or ctx is done.
+func NewRetryNotifier(notifier Notifier, config RetryConfig) Notifier {
+	if notifier == nil {
+		panic("NewRetryNotifier: notifier must not be nil")
+	}
+
+	config.setDefaults()
+	desc := fmt.Sprintf("Retry(notifier=%s, attempts=%d)", notifier.String(), config.MaxAttempts)
+	return NewNotifier(desc, func(ctx context.Context, emails ...Email) (err error) {
+		start := time.Now()
+		for attempt := 1; ; attempt++ {
+			if err = notifier.Notify(ctx, emails...); err == nil || !IsRetryable(err) {
+				return
+			} else if attempt >= config.MaxAttempts {
+				return fmt.Errorf("give up after %d attempts: %w", attempt, err)
+			}
+
+			backoff := config.backoff(attempt)
+			if config.MaxElapsedTime > 0 && time.Since(start)+backoff > config.MaxElapsedTime {
+				return fmt.Errorf("give up after %d attempts for exceeding max elapsed time: %w", attempt, err)
+			}
+			if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < backoff {
+				return fmt.Errorf("give up after %d attempts for reaching the deadline: %w", attempt, err)
+			}
+
+			slog.Warn("fail to send notice, and retry later", "notifier", notifier.String(),
+				"attempt", attempt, "backoff", backoff, "err", err)
+
+			timer := time.NewTimer(backoff)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return errors.Join(err, ctx.Err())
+			}
+		}
+	})
+}
@@ -0,0 +1,160 @@
+// Copyright 2023 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package slack provides a function to send the message notice
+// by slack incoming webhook.
+package slack
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/xgfone/emailmanager/pkg/notice"
+	"github.com/xgfone/go-binder"
+	"github.com/xgfone/go-structs"
+)
+
+// Slack allows at most 50 blocks in a message.
+const maxBlocks = 50
+
+func init() {
+	notice.RegisterNotifierBuilder("slackwebhook", func(configs map[string]interface{}) (notice.Notifier, error) {
+		var config WebhookConfig
+		if err := binder.BindStructToMap(&config, "json", configs); err != nil {
+			return nil, err
+		}
+		if err := structs.Reflect(config); err != nil {
+			return nil, err
+		}
+		return NewWebhookNotifier(config), nil
+	})
+}
+
+// WebhookConfig is the slack incoming webhook config.
+type WebhookConfig struct {
+	URL string `validate:"required" json:"Url"`
+
+	// Optional, which override the defaults of the incoming webhook.
+	Channel   string
+	Username  string
+	IconEmoji string
+	IconURL   string `json:"IconUrl"`
+
+	// The maximum number of the emails shown in a message,
+	// and the overflow is summarised. Default: 10
+	MaxEmails int
+}
+
+// NewWebhookNotifier returns a notifier based on slack incoming webhook.
+func NewWebhookNotifier(config WebhookConfig) notice.Notifier {
+	if config.URL == "" {
+		panic("NewWebhookNotifier: url must not be empty")
+	}
+
+	switch {
+	case config.MaxEmails <= 0:
+		config.MaxEmails = 10
+	case config.MaxEmails > maxBlocks-2:
+		config.MaxEmails = maxBlocks - 2
+	}
+
+	desc := fmt.Sprintf("SlackWebhook(channel=%s)", config.Channel)
+	return notice.NewNotifier(desc, func(ctx context.Context, emails ...notice.Email) error {
+		return SendWebhook(ctx, config, emails...)
+	})
+}
+
+// SendWebhook sends the webhook message notice with Block Kit.
+func SendWebhook(ctx context.Context, config WebhookConfig, emails ...notice.Email) (err error) {
+	if len(emails) == 0 {
+		return
+	}
+
+	text := escape(notice.Title(ctx, fmt.Sprintf("You have %d unread emails:", len(emails))))
+	blocks := make([]interface{}, 0, len(emails)+2)
+	blocks = append(blocks, markdownSection(text))
+	for i, email := range emails {
+		if i >= config.MaxEmails {
+			blocks = append(blocks, markdownSection("......"))
+			break
+		}
+
+		blocks = append(blocks, map[string]interface{}{
+			"type": "section",
+			"text": markdownText(fmt.Sprintf("%d. *%s*", i+1, escape(email.Subject))),
+			"fields": []interface{}{
+				markdownText("*Sender:*\n" + escape(email.Sender())),
+				markdownText("*Mailbox:*\n" + escape(email.Mailbox())),
+				markdownText("*Date:*\n" + email.Date().Format(time.RFC3339)),
+			},
+		})
+	}
+
+	msg := map[string]interface{}{"text": text, "blocks": blocks}
+	setIfNotEmpty(msg, "channel", config.Channel)
+	setIfNotEmpty(msg, "username", config.Username)
+	setIfNotEmpty(msg, "icon_emoji", config.IconEmoji)
+	setIfNotEmpty(msg, "icon_url", config.IconURL)
+
+	body := bytes.NewBuffer(make([]byte, 0, 1024))
+	if err = json.NewEncoder(body).Encode(msg); err != nil {
+		return
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, config.URL, body)
+	if err != nil {
+		return
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return
+	} else if result := strings.TrimSpace(string(data)); result != "ok" {
+		return notice.NewStatusError(resp.StatusCode, []byte(result))
+	}
+
+	return
+}
+
+func markdownText(text string) map[string]interface{} {
+	return map[string]interface{}{"type": "mrkdwn", "text": text}
+}
+
+func markdownSection(text string) map[string]interface{} {
+	return map[string]interface{}{"type": "section", "text": markdownText(text)}
+}
+
+func setIfNotEmpty(m map[string]interface{}, key, value string) {
+	if value != "" {
+		m[key] = value
+	}
+}
+
+var escaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// escape escapes the control characters of slack mrkdwn.
+func escape(s string) string { return escaper.Replace(s) }
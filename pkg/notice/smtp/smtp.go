@@ -0,0 +1,207 @@
+// Copyright 2023 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package smtp provides a function to send the digest of the emails
+// to the recipients by the SMTP server.
+package smtp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/xgfone/emailmanager/pkg/email"
+	"github.com/xgfone/emailmanager/pkg/notice"
+	"github.com/xgfone/go-binder"
+	"github.com/xgfone/go-structs"
+)
+
+// Predefine the default templates of the digest email.
+const (
+	DefaultSubject = `{{ with controller }}[{{ . }}] {{ end }}You have {{ len . }} unread emails`
+	DefaultBody    = `You have {{ len . }} unread emails:
+{{ range $i, $e := . }}
+{{ inc $i }}. [{{ $e.Mailbox }}] {{ $e.Subject }}
+   From: {{ $e.Sender }}
+   Date: {{ $e.Date.Format "2006-01-02 15:04:05 -0700" }}
+{{- if $e.Text }}
+   {{ $e.Preview 100 }}
+{{- end }}
+{{ end }}`
+)
+
+func init() {
+	notice.RegisterNotifierBuilder("smtp", func(configs map[string]interface{}) (notice.Notifier, error) {
+		var config Config
+		if err := binder.BindStructToMap(&config, "json", configs); err != nil {
+			return nil, err
+		}
+		if err := structs.Reflect(config); err != nil {
+			return nil, err
+		}
+		return NewSMTPNotifier(config)
+	})
+}
+
+// Config is the config of the smtp notifier.
+type Config struct {
+	email.SMTPConfig
+
+	// From and To are the RFC 5322 addresses, which may have the display
+	// names, such as "Alice <alice@example.com>".
+	From string   `validate:"required"`
+	To   []string `validate:"required"`
+
+	// Subject and Body are the text/template rendered with the emails,
+	// []notice.Email. Besides the builtin functions and notice.TemplateFuncs,
+	// "inc" is provided to add 1 to an integer.
+	//
+	// If HTML is true, Body is the html/template instead, which escapes
+	// the fields of the emails, such as the subjects and the senders.
+	//
+	// Default: DefaultSubject and DefaultBody
+	Subject string
+	Body    string
+
+	// If true, the body is sent as text/html instead of text/plain.
+	HTML bool `json:"Html"`
+}
+
+var funcs = template.FuncMap{
+	"inc": func(i int) int { return i + 1 },
+}
+
+// NewSMTPNotifier returns a notifier which sends the digest of the emails
+// by the SMTP server.
+func NewSMTPNotifier(config Config) (notice.Notifier, error) {
+	if config.From == "" {
+		panic("NewSMTPNotifier: from must not be empty")
+	}
+	if len(config.To) == 0 {
+		panic("NewSMTPNotifier: to must not be empty")
+	}
+
+	if err := config.SMTPConfig.Check(); err != nil {
+		return nil, err
+	}
+	if config.Subject == "" {
+		config.Subject = DefaultSubject
+	}
+	if config.Body == "" {
+		config.Body = DefaultBody
+	}
+
+	from, err := mail.ParseAddress(config.From)
+	if err != nil {
+		return nil, fmt.Errorf("invalid from address '%s': %w", config.From, err)
+	}
+
+	to := make([]*mail.Address, len(config.To))
+	for i, addr := range config.To {
+		if to[i], err = mail.ParseAddress(addr); err != nil {
+			return nil, fmt.Errorf("invalid to address '%s': %w", addr, err)
+		}
+	}
+
+	subject, err := template.New("subject").Funcs(notice.TemplateFuncs).Funcs(funcs).Parse(config.Subject)
+	if err != nil {
+		return nil, fmt.Errorf("invalid subject template: %w", err)
+	}
+
+	var body bodyTemplate
+	if config.HTML {
+		tmpl, err := htmltemplate.New("body").Funcs(notice.TemplateFuncs).Funcs(funcs).Parse(config.Body)
+		if err != nil {
+			return nil, fmt.Errorf("invalid body template: %w", err)
+		}
+		body = func(ctx context.Context, w io.Writer, emails []notice.Email) error {
+			return notice.ExecuteHTMLTemplate(ctx, w, tmpl, emails)
+		}
+	} else {
+		tmpl, err := template.New("body").Funcs(notice.TemplateFuncs).Funcs(funcs).Parse(config.Body)
+		if err != nil {
+			return nil, fmt.Errorf("invalid body template: %w", err)
+		}
+		body = func(ctx context.Context, w io.Writer, emails []notice.Email) error {
+			return notice.ExecuteTemplate(ctx, w, tmpl, emails)
+		}
+	}
+
+	d := digest{config: config, from: from, to: to, subject: subject, body: body}
+	desc := fmt.Sprintf("SMTP(addr=%s, to=%s)", config.Addr, strings.Join(config.To, ","))
+	return notice.NewNotifier(desc, func(ctx context.Context, emails ...notice.Email) error {
+		return d.send(ctx, emails)
+	}), nil
+}
+
+type bodyTemplate func(ctx context.Context, w io.Writer, emails []notice.Email) error
+
+type digest struct {
+	config  Config
+	from    *mail.Address
+	to      []*mail.Address
+	subject *template.Template
+	body    bodyTemplate
+}
+
+func (d digest) send(ctx context.Context, emails []notice.Email) (err error) {
+	if len(emails) == 0 {
+		return
+	}
+
+	var buf strings.Builder
+	if err = notice.ExecuteTemplate(ctx, &buf, d.subject, emails); err != nil {
+		return
+	}
+	subjectText := strings.Join(strings.Fields(buf.String()), " ")
+
+	ctype := "text/plain"
+	if d.config.HTML {
+		ctype = "text/html"
+	}
+
+	// mail.Address.String encodes the non-ASCII display name by RFC 2047.
+	to := make([]string, len(d.to))
+	rcpts := make([]string, len(d.to))
+	for i, addr := range d.to {
+		to[i], rcpts[i] = addr.String(), addr.Address
+	}
+
+	msg := bytes.NewBuffer(make([]byte, 0, 1024))
+	fmt.Fprintf(msg, "From: %s\r\n", d.from.String())
+	fmt.Fprintf(msg, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subjectText))
+	fmt.Fprintf(msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(msg, "Content-Type: %s; charset=utf-8\r\n", ctype)
+	fmt.Fprintf(msg, "Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+
+	w := quotedprintable.NewWriter(msg)
+	if err = d.body(ctx, w, emails); err != nil {
+		return
+	}
+	if err = w.Close(); err != nil {
+		return
+	}
+
+	return email.SendMail(ctx, d.config.SMTPConfig, d.from.Address, rcpts, msg.Bytes())
+}
@@ -0,0 +1,103 @@
+// Copyright 2023 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package smtp
+
+import (
+	"context"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"testing"
+
+	"github.com/xgfone/emailmanager/pkg/email"
+	"github.com/xgfone/emailmanager/pkg/internal/smtptest"
+	"github.com/xgfone/emailmanager/pkg/notice"
+)
+
+func TestSMTPNotifier(t *testing.T) {
+	tests := []struct {
+		html   bool
+		ctype  string
+		expect []string
+	}{
+		{false, "text/plain; charset=utf-8", []string{"1. [] <b>R&D</b>", "From: a&b@example.com"}},
+		{true, "text/html; charset=utf-8", []string{"1. [] &lt;b&gt;R&amp;D&lt;/b&gt;", "From: a&amp;b@example.com"}},
+	}
+
+	for _, test := range tests {
+		s := smtptest.NewServer(smtptest.TLSStartTLS, "user", "pass")
+		n, err := NewSMTPNotifier(Config{
+			SMTPConfig: email.SMTPConfig{
+				Addr:               s.Addr,
+				Username:           "user",
+				Password:           "pass",
+				InsecureSkipVerify: true,
+			},
+			From: "Email Manager <a@example.com>",
+			To:   []string{"张三 <b@example.com>"},
+			HTML: test.html,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		emails := make([]notice.Email, 1)
+		emails[0].Subject = `<b>R&D</b>`
+		emails[0].Froms = []email.Address{{Addr: "a&b@example.com"}}
+
+		ctx := notice.WithController(context.Background(), "ctrl")
+		err = n.Notify(ctx, emails...)
+		s.Close()
+		if err != nil {
+			t.Fatalf("html=%v: %v", test.html, err)
+		}
+
+		msgs := s.Messages()
+		if len(msgs) != 1 {
+			t.Fatalf("html=%v: expect 1 message, but got %d", test.html, len(msgs))
+		}
+		if msgs[0].From != "a@example.com" || len(msgs[0].To) != 1 || msgs[0].To[0] != "b@example.com" {
+			t.Errorf("html=%v: unexpected envelope: from=%s, to=%v", test.html, msgs[0].From, msgs[0].To)
+		}
+
+		msg, err := mail.ReadMessage(strings.NewReader(msgs[0].Data))
+		if err != nil {
+			t.Fatalf("html=%v: %v", test.html, err)
+		}
+
+		subject, _ := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+		if expect := "[ctrl] You have 1 unread emails"; subject != expect {
+			t.Errorf("html=%v: expect subject '%s', but got '%s'", test.html, expect, subject)
+		}
+		if to, _ := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("To")); to != "张三 <b@example.com>" {
+			t.Errorf("html=%v: unexpected to '%s'", test.html, to)
+		}
+		if ctype := msg.Header.Get("Content-Type"); ctype != test.ctype {
+			t.Errorf("html=%v: expect content type '%s', but got '%s'", test.html, test.ctype, ctype)
+		}
+
+		body, err := io.ReadAll(quotedprintable.NewReader(msg.Body))
+		if err != nil {
+			t.Fatalf("html=%v: %v", test.html, err)
+		}
+		for _, expect := range test.expect {
+			if !strings.Contains(string(body), expect) {
+				t.Errorf("html=%v: expect the body contains '%s', but got '%s'", test.html, expect, body)
+			}
+		}
+	}
+}
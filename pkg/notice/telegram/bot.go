@@ -0,0 +1,282 @@
+// Copyright 2023 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package telegram provides a function to send the message notice
+// by telegram bot api.
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/xgfone/emailmanager/pkg/notice"
+	"github.com/xgfone/go-binder"
+	"github.com/xgfone/go-structs"
+)
+
+// DefaultBaseURL is the default base url of telegram bot api.
+const DefaultBaseURL = "https://api.telegram.org"
+
+// maxMessageLen is the maximum length of the text of a telegram message.
+const maxMessageLen = 4096
+
+// maxFieldLen is the maximum length of the escaped mailbox or sender of a line.
+const maxFieldLen = 256
+
+// Predefine some parse modes.
+const (
+	ParseModeHTML       = "HTML"
+	ParseModeMarkdownV2 = "MarkdownV2"
+)
+
+func init() {
+	notice.RegisterNotifierBuilder("telegram", func(configs map[string]interface{}) (notice.Notifier, error) {
+		var config BotConfig
+		if err := binder.BindStructToMap(&config, "json", configs); err != nil {
+			return nil, err
+		}
+		if err := structs.Reflect(config); err != nil {
+			return nil, err
+		}
+		return NewBotNotifier(config)
+	})
+}
+
+// BotConfig is the telegram bot config.
+type BotConfig struct {
+	BotToken  string   `validate:"required"`
+	ChatIDs   []string `validate:"required" json:"ChatIds"`
+	ParseMode string   // "HTML" or "MarkdownV2". Default: "HTML"
+
+	// BaseURL is the base url of the bot api, which may be set to
+	// a local stand-in server for test. Default: DefaultBaseURL
+	BaseURL string `json:"BaseUrl"`
+
+	// MaxRetries is the maximum number of the retries when the api
+	// responds 429 with retry_after. Default: 3
+	MaxRetries int
+}
+
+// NewBotNotifier returns a notifier based on telegram bot api.
+func NewBotNotifier(config BotConfig) (notice.Notifier, error) {
+	if config.BotToken == "" {
+		panic("NewBotNotifier: bot token must not be empty")
+	}
+	if len(config.ChatIDs) == 0 {
+		panic("NewBotNotifier: chat ids must not be empty")
+	}
+
+	switch config.ParseMode {
+	case "":
+		config.ParseMode = ParseModeHTML
+	case ParseModeHTML, ParseModeMarkdownV2:
+	default:
+		return nil, fmt.Errorf("unsupported telegram parse mode '%s'", config.ParseMode)
+	}
+
+	if config.BaseURL == "" {
+		config.BaseURL = DefaultBaseURL
+	} else {
+		config.BaseURL = strings.TrimRight(config.BaseURL, "/")
+	}
+
+	if config.MaxRetries <= 0 {
+		config.MaxRetries = 3
+	}
+
+	desc := fmt.Sprintf("Telegram(chatids=%s)", strings.Join(config.ChatIDs, ","))
+	return notice.NewNotifier(desc, func(ctx context.Context, emails ...notice.Email) error {
+		return SendMessage(ctx, config, emails...)
+	}), nil
+}
+
+// SendMessage sends the message notice to all the chats,
+// which is split into multiple messages if it is too long.
+func SendMessage(ctx context.Context, config BotConfig, emails ...notice.Email) (err error) {
+	for _, chatid := range config.ChatIDs {
+		if _err := sendChatMessage(ctx, config, chatid, emails...); _err != nil {
+			err = errors.Join(err, fmt.Errorf("chat %s: %w", chatid, _err))
+		}
+	}
+	return
+}
+
+func sendChatMessage(ctx context.Context, config BotConfig, chatid string, emails ...notice.Email) (err error) {
+	if len(emails) == 0 {
+		return
+	}
+
+	escape := escapeHTML
+	format := "%d. [%s] <b>%s</b> (%s)"
+	if config.ParseMode == ParseModeMarkdownV2 {
+		escape = escapeMarkdownV2
+		format = "%d\\. \\[%s\\] *%s* \\(%s\\)"
+	}
+
+	// Truncate the raw text before escaping it, not the escaped line,
+	// which may break the html entity or leave a dangling backslash.
+	lines := make([]string, 1, len(emails)+1)
+	lines[0] = truncate(notice.Title(ctx, fmt.Sprintf("You have %d unread emails:", len(emails))),
+		maxMessageLen, escape)
+	for i, email := range emails {
+		mailbox := truncate(email.Mailbox(), maxFieldLen, escape)
+		sender := truncate(email.Sender(), maxFieldLen, escape)
+		fixed := utf8.RuneCountInString(fmt.Sprintf(format, i+1, mailbox, "", sender))
+		subject := truncate(email.Subject, maxMessageLen-fixed, escape)
+		lines = append(lines, fmt.Sprintf(format, i+1, mailbox, subject, sender))
+	}
+
+	for _, text := range splitMessage(lines, maxMessageLen) {
+		if err = sendMessage(ctx, config, chatid, text); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func sendMessage(ctx context.Context, config BotConfig, chatid, text string) error {
+	for retries := 0; ; retries++ {
+		retryAfter, err := sendMessageOnce(ctx, config, chatid, text)
+		if retryAfter <= 0 || retries >= config.MaxRetries {
+			return err
+		}
+
+		timer := time.NewTimer(retryAfter)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return errors.Join(err, ctx.Err())
+		}
+	}
+}
+
+func sendMessageOnce(ctx context.Context, config BotConfig, chatid, text string) (retryAfter time.Duration, err error) {
+	body := bytes.NewBuffer(make([]byte, 0, len(text)+128))
+	err = json.NewEncoder(body).Encode(map[string]interface{}{
+		"chat_id":                  chatid,
+		"text":                     text,
+		"parse_mode":               config.ParseMode,
+		"disable_web_page_preview": true,
+	})
+	if err != nil {
+		return
+	}
+
+	url := fmt.Sprintf("%s/bot%s/sendMessage", config.BaseURL, config.BotToken)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, body)
+	if err != nil {
+		return
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		OK          bool   `json:"ok"`
+		ErrorCode   int    `json:"error_code"`
+		Description string `json:"description"`
+		Parameters  struct {
+			RetryAfter int `json:"retry_after"`
+		} `json:"parameters"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return
+	} else if !result.OK {
+		err = fmt.Errorf("error_code=%d, description=%s", result.ErrorCode, result.Description)
+		switch {
+		case resp.StatusCode == http.StatusTooManyRequests:
+			if result.Parameters.RetryAfter > 0 {
+				retryAfter = time.Duration(result.Parameters.RetryAfter) * time.Second
+			}
+		case resp.StatusCode >= 400 && resp.StatusCode < 500:
+			// Such as the invalid bot token or chat id.
+			err = notice.PermanentError(err)
+		}
+	}
+
+	return
+}
+
+// splitMessage joins the lines into the texts, each of which is no longer
+// than maxlen characters, and each line must be no longer than maxlen.
+func splitMessage(lines []string, maxlen int) (texts []string) {
+	var b strings.Builder
+	var n int
+	for _, line := range lines {
+		linelen := utf8.RuneCountInString(line)
+		if n > 0 && n+1+linelen > maxlen {
+			texts = append(texts, b.String())
+			b.Reset()
+			n = 0
+		}
+
+		if n > 0 {
+			b.WriteByte('\n')
+			n++
+		}
+		b.WriteString(line)
+		n += linelen
+	}
+
+	if n > 0 {
+		texts = append(texts, b.String())
+	}
+	return
+}
+
+// truncate escapes s, and truncates it with the suffix "..." if the escaped
+// text is longer than maxlen characters, which is done rune by rune before
+// escaping, so that no escaped sequence is broken.
+func truncate(s string, maxlen int, escape func(string) string) string {
+	if escaped := escape(s); utf8.RuneCountInString(escaped) <= maxlen {
+		return escaped
+	}
+
+	suffix := escape("...")
+	var b strings.Builder
+	n := utf8.RuneCountInString(suffix)
+	for _, r := range s {
+		escaped := escape(string(r))
+		if n += utf8.RuneCountInString(escaped); n > maxlen {
+			break
+		}
+		b.WriteString(escaped)
+	}
+	b.WriteString(suffix)
+	return b.String()
+}
+
+func escapeHTML(s string) string { return html.EscapeString(s) }
+
+var markdownV2Escaper = strings.NewReplacer(
+	`\`, `\\`, "_", `\_`, "*", `\*`, "[", `\[`, "]", `\]`, "(", `\(`, ")", `\)`,
+	"~", `\~`, "`", "\\`", ">", `\>`, "#", `\#`, "+", `\+`, "-", `\-`, "=", `\=`,
+	"|", `\|`, "{", `\{`, "}", `\}`, ".", `\.`, "!", `\!`,
+)
+
+func escapeMarkdownV2(s string) string { return markdownV2Escaper.Replace(s) }
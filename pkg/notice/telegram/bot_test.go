@@ -0,0 +1,211 @@
+// Copyright 2023 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/xgfone/emailmanager/pkg/email"
+	"github.com/xgfone/emailmanager/pkg/notice"
+)
+
+// botServer is a local stand-in of the telegram bot api, which responds
+// the queued responses in turn, or success if no response is queued.
+type botServer struct {
+	*httptest.Server
+
+	lock      sync.Mutex
+	texts     []string
+	responses []string // "status body"
+}
+
+func newBotServer(t *testing.T) *botServer {
+	s := &botServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/bottoken/sendMessage" {
+			t.Errorf("unexpected path '%s'", r.URL.Path)
+		}
+
+		var req struct {
+			ChatID    string `json:"chat_id"`
+			Text      string `json:"text"`
+			ParseMode string `json:"parse_mode"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("fail to decode the request: %v", err)
+		}
+
+		s.lock.Lock()
+		defer s.lock.Unlock()
+
+		status, body := http.StatusOK, `{"ok":true}`
+		if len(s.responses) > 0 {
+			fmt.Sscanf(s.responses[0], "%d", &status)
+			body = s.responses[0][strings.IndexByte(s.responses[0], ' ')+1:]
+			s.responses = s.responses[1:]
+		}
+		if status == http.StatusOK {
+			s.texts = append(s.texts, req.Text)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *botServer) config(parseMode string) BotConfig {
+	return BotConfig{
+		BotToken:   "token",
+		ChatIDs:    []string{"1"},
+		ParseMode:  parseMode,
+		BaseURL:    s.URL,
+		MaxRetries: 3,
+	}
+}
+
+func newEmails(subjects ...string) []notice.Email {
+	emails := make([]notice.Email, len(subjects))
+	for i, subject := range subjects {
+		emails[i].Subject = subject
+		emails[i].Froms = []email.Address{{Addr: "a_b@example.com"}}
+	}
+	return emails
+}
+
+func TestSendMessageSplit(t *testing.T) {
+	server := newBotServer(t)
+
+	subjects := make([]string, 100)
+	for i := range subjects {
+		subjects[i] = fmt.Sprintf("%03d %s", i, strings.Repeat("中", 200))
+	}
+
+	err := SendMessage(context.Background(), server.config(ParseModeHTML), newEmails(subjects...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(server.texts) < 2 {
+		t.Fatalf("expect the multiple messages, but got %d", len(server.texts))
+	}
+
+	var lines []string
+	for _, text := range server.texts {
+		if n := utf8.RuneCountInString(text); n > maxMessageLen {
+			t.Errorf("the message is too long: %d", n)
+		}
+		lines = append(lines, strings.Split(text, "\n")...)
+	}
+
+	// No line is split into the different messages.
+	if len(lines) != len(subjects)+1 {
+		t.Fatalf("expect %d lines, but got %d", len(subjects)+1, len(lines))
+	}
+	for i, subject := range subjects {
+		if !strings.Contains(lines[i+1], subject) {
+			t.Errorf("line %d: missing the subject '%s'", i+1, subject[:3])
+		}
+	}
+}
+
+func TestSendMessageEscape(t *testing.T) {
+	tests := []struct {
+		parseMode string
+		subject   string
+		expect    string
+	}{
+		{ParseModeHTML, `<b>R&D</b>`, "1. [] <b>&lt;b&gt;R&amp;D&lt;/b&gt;</b> (a_b@example.com)"},
+		{ParseModeMarkdownV2, `[v1.0] *done*!`, `1\. \[\] *\[v1\.0\] \*done\*\!* \(a\_b@example\.com\)`},
+	}
+
+	for _, test := range tests {
+		server := newBotServer(t)
+		err := SendMessage(context.Background(), server.config(test.parseMode), newEmails(test.subject)...)
+		if err != nil {
+			t.Fatalf("%s: %v", test.parseMode, err)
+		}
+
+		if len(server.texts) != 1 {
+			t.Fatalf("%s: expect 1 message, but got %d", test.parseMode, len(server.texts))
+		}
+		if lines := strings.Split(server.texts[0], "\n"); len(lines) != 2 || lines[1] != test.expect {
+			t.Errorf("%s: expect '%s', but got '%s'", test.parseMode, test.expect, server.texts[0])
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		s      string
+		maxlen int
+		escape func(string) string
+		expect string
+	}{
+		{"a&b", 10, escapeHTML, "a&amp;b"},
+		{"a&b&c", 10, escapeHTML, "a&amp;b..."},
+		{"&&&&", 8, escapeHTML, "&amp;..."},
+		{"a.b.c.d", 8, escapeMarkdownV2, `a\.\.\.`},
+		{"a_b_c_d", 9, escapeMarkdownV2, `a\_\.\.\.`},
+		{"中文中文", 4, escapeHTML, "中文中文"},
+		{"中文中文中", 4, escapeHTML, "中..."},
+	}
+
+	for _, test := range tests {
+		if s := truncate(test.s, test.maxlen, test.escape); s != test.expect {
+			t.Errorf("'%s': expect '%s', but got '%s'", test.s, test.expect, s)
+		} else if n := utf8.RuneCountInString(s); n > test.maxlen {
+			t.Errorf("'%s': the truncated is too long: %d", test.s, n)
+		}
+	}
+}
+
+func TestSendMessageRetryAfter(t *testing.T) {
+	server := newBotServer(t)
+	server.responses = []string{
+		`429 {"ok":false,"error_code":429,"description":"Too Many Requests","parameters":{"retry_after":1}}`,
+	}
+
+	err := SendMessage(context.Background(), server.config(ParseModeHTML), newEmails("a")...)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(server.texts) != 1 {
+		t.Errorf("expect 1 message sent after retrying, but got %d", len(server.texts))
+	}
+}
+
+func TestSendMessagePermanentError(t *testing.T) {
+	server := newBotServer(t)
+	server.responses = []string{
+		`400 {"ok":false,"error_code":400,"description":"Bad Request: chat not found"}`,
+	}
+
+	err := SendMessage(context.Background(), server.config(ParseModeHTML), newEmails("a")...)
+	if err == nil {
+		t.Fatal("expect an error, but got nil")
+	} else if notice.IsRetryable(err) {
+		t.Errorf("expect the error not to be retryable: %v", err)
+	}
+}
@@ -0,0 +1,194 @@
+// Copyright 2023 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package webhook provides a function to send the message notice
+// by the generic http webhook.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/xgfone/emailmanager/pkg/notice"
+	"github.com/xgfone/go-binder"
+	"github.com/xgfone/go-structs"
+)
+
+// DefaultBody is the default body template, which renders the emails as json.
+const DefaultBody = `{{ json . }}`
+
+func init() {
+	notice.RegisterNotifierBuilder("webhook", func(configs map[string]interface{}) (notice.Notifier, error) {
+		var config Config
+		if err := binder.BindStructToMap(&config, "json", configs); err != nil {
+			return nil, err
+		}
+		if err := structs.Reflect(config); err != nil {
+			return nil, err
+		}
+		return NewWebhookNotifier(config)
+	})
+}
+
+// Config is the webhook config.
+type Config struct {
+	URL     string `validate:"required" json:"Url"`
+	Method  string // Default: POST
+	Headers map[string]string
+
+	// Body is the text/template rendered with the emails, []notice.Email.
+	// Besides the builtin functions and notice.TemplateFuncs, "json" is
+	// provided to encode a value to the json string.
+	//
+	// Default: DefaultBody
+	Body string
+
+	Success SuccessConfig
+}
+
+// SuccessConfig is used to check whether the webhook is sent successfully.
+type SuccessConfig struct {
+	// The range of the success status code. Default: [200, 299]
+	MinStatus int
+	MaxStatus int
+
+	// If JSONPath is set, the response body is decoded as json, and the value
+	// of the path, such as "code" or "data.items.0.status", must be equal to
+	// JSONValue by comparing their string formats.
+	JSONPath  string `json:"JsonPath"`
+	JSONValue string `json:"JsonValue"`
+}
+
+var funcs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+// NewWebhookNotifier returns a notifier based on the generic webhook.
+func NewWebhookNotifier(config Config) (notice.Notifier, error) {
+	if config.URL == "" {
+		panic("NewWebhookNotifier: url must not be empty")
+	}
+
+	if config.Method == "" {
+		config.Method = http.MethodPost
+	}
+	if config.Body == "" {
+		config.Body = DefaultBody
+	}
+	if config.Success.MinStatus <= 0 {
+		config.Success.MinStatus = 200
+	}
+	if config.Success.MaxStatus <= 0 {
+		config.Success.MaxStatus = 299
+	}
+
+	tmpl, err := template.New("body").Funcs(notice.TemplateFuncs).Funcs(funcs).Parse(config.Body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid body template: %w", err)
+	}
+
+	desc := fmt.Sprintf("Webhook(method=%s, url=%s)", config.Method, config.URL)
+	return notice.NewNotifier(desc, func(ctx context.Context, emails ...notice.Email) error {
+		return sendWebhook(ctx, config, tmpl, emails)
+	}), nil
+}
+
+func sendWebhook(ctx context.Context, config Config, tmpl *template.Template, emails []notice.Email) (err error) {
+	if len(emails) == 0 {
+		return
+	}
+
+	body := bytes.NewBuffer(make([]byte, 0, 512))
+	if err = notice.ExecuteTemplate(ctx, body, tmpl, emails); err != nil {
+		return notice.PermanentError(err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, config.Method, config.URL, body)
+	if err != nil {
+		return
+	}
+
+	for key, value := range config.Headers {
+		req.Header.Set(key, value)
+	}
+	if req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return
+	}
+
+	if resp.StatusCode < config.Success.MinStatus || resp.StatusCode > config.Success.MaxStatus {
+		return notice.NewStatusError(resp.StatusCode, data)
+	}
+
+	if config.Success.JSONPath != "" {
+		var result interface{}
+		if err = json.Unmarshal(data, &result); err != nil {
+			return fmt.Errorf("fail to decode the response body: %w", err)
+		}
+
+		value, ok := lookupJSONPath(result, config.Success.JSONPath)
+		if !ok {
+			return fmt.Errorf("no json path '%s': body=%s", config.Success.JSONPath, data)
+		} else if s := fmt.Sprint(value); s != config.Success.JSONValue {
+			return fmt.Errorf("%s=%s, body=%s", config.Success.JSONPath, s, data)
+		}
+	}
+
+	return
+}
+
+// lookupJSONPath returns the value of the dot-separated path in the decoded
+// json value, which uses the index for the array, such as "data.items.0".
+func lookupJSONPath(value interface{}, path string) (interface{}, bool) {
+	for _, key := range strings.Split(path, ".") {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if value, ok = v[key]; !ok {
+				return nil, false
+			}
+
+		case []interface{}:
+			index, err := strconv.Atoi(key)
+			if err != nil || index < 0 || index >= len(v) {
+				return nil, false
+			}
+			value = v[index]
+
+		default:
+			return nil, false
+		}
+	}
+	return value, true
+}
@@ -0,0 +1,211 @@
+// Copyright 2023 xgfone
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package wecom provides a function to send the message notice
+// by wecom (wechat work) group robot.
+package wecom
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/xgfone/emailmanager/pkg/notice"
+	"github.com/xgfone/go-binder"
+	"github.com/xgfone/go-structs"
+)
+
+const urlprefix = "https://qyapi.weixin.qq.com/cgi-bin/webhook/send?key="
+
+// WeCom allows at most 8 articles in a news message.
+const maxArticles = 8
+
+// Predefine some message types.
+const (
+	MsgTypeText     = "text"
+	MsgTypeMarkdown = "markdown"
+	MsgTypeNews     = "news"
+)
+
+func init() {
+	notice.RegisterNotifierBuilder("wecomwebhook", func(configs map[string]interface{}) (notice.Notifier, error) {
+		var config WebhookConfig
+		if err := binder.BindStructToMap(&config, "json", configs); err != nil {
+			return nil, err
+		}
+		if err := structs.Reflect(config); err != nil {
+			return nil, err
+		}
+		return NewWebhookNotifier(config)
+	})
+}
+
+// WebhookConfig is the wecom group robot webhook config.
+type WebhookConfig struct {
+	Key     string `validate:"required"`
+	MsgType string // "text", "markdown" or "news". Default: "text"
+
+	// Only for the message type "text". For "markdown", the users
+	// in MentionedList are mentioned by "<@userid>" in the content.
+	MentionedList       []string
+	MentionedMobileList []string
+
+	// Only for the message type "news", which shows at most 8 emails,
+	// or 7 emails and the number of the rest if more.
+	//
+	// NewsURL is required, which is opened when clicking the article,
+	// such as the url of the webmail.
+	NewsURL    string `json:"NewsUrl"`
+	NewsPicURL string `json:"NewsPicUrl"`
+}
+
+// NewWebhookNotifier returns a notifier based on wecom group robot webhook.
+func NewWebhookNotifier(config WebhookConfig) (notice.Notifier, error) {
+	if config.Key == "" {
+		panic("NewWebhookNotifier: key must not be empty")
+	}
+
+	switch config.MsgType {
+	case "":
+		config.MsgType = MsgTypeText
+	case MsgTypeText, MsgTypeMarkdown:
+	case MsgTypeNews:
+		if config.NewsURL == "" {
+			return nil, fmt.Errorf("missing the news url for the wecom news message")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported wecom message type '%s'", config.MsgType)
+	}
+
+	desc := fmt.Sprintf("WeComWebhook(msgtype=%s)", config.MsgType)
+	return notice.NewNotifier(desc, func(ctx context.Context, emails ...notice.Email) error {
+		return SendWebhook(ctx, config, emails...)
+	}), nil
+}
+
+// SendWebhook sends the webhook message notice.
+func SendWebhook(ctx context.Context, config WebhookConfig, emails ...notice.Email) (err error) {
+	if len(emails) == 0 {
+		return
+	}
+
+	title := notice.Title(ctx, fmt.Sprintf("您有%d封未读邮件", len(emails)))
+	msg := map[string]interface{}{"msgtype": config.MsgType}
+	switch config.MsgType {
+	case MsgTypeNews:
+		// If too many, the last article tells the number of the rest emails.
+		shown := emails
+		if len(emails) > maxArticles {
+			shown = emails[:maxArticles-1]
+		}
+
+		articles := make([]interface{}, 0, maxArticles)
+		for _, email := range shown {
+			articles = append(articles, map[string]interface{}{
+				"title":       email.Subject,
+				"description": fmt.Sprintf("[%s] %s", email.Mailbox(), email.Sender()),
+				"url":         config.NewsURL,
+				"picurl":      config.NewsPicURL,
+			})
+		}
+		if rest := len(emails) - len(shown); rest > 0 {
+			articles = append(articles, map[string]interface{}{
+				"title":       fmt.Sprintf("……还有%d封未读邮件", rest),
+				"description": title,
+				"url":         config.NewsURL,
+				"picurl":      config.NewsPicURL,
+			})
+		}
+		msg["news"] = map[string]interface{}{"articles": articles}
+
+	case MsgTypeMarkdown:
+		contents := make([]string, 1, len(emails)+2)
+		contents[0] = fmt.Sprintf("**%s:**", title)
+		for i, email := range emails {
+			if i > 10 {
+				contents = append(contents, "......")
+				break
+			}
+			contents = append(contents, fmt.Sprintf("%d. [%s] %s(<font color=\"comment\">%s</font>)",
+				i+1, email.Mailbox(), email.Subject, email.Sender()))
+		}
+		if len(config.MentionedList) > 0 {
+			mentions := make([]string, len(config.MentionedList))
+			for i, userid := range config.MentionedList {
+				mentions[i] = fmt.Sprintf("<@%s>", userid)
+			}
+			contents = append(contents, strings.Join(mentions, " "))
+		}
+		msg["markdown"] = map[string]interface{}{"content": strings.Join(contents, "\n")}
+
+	default:
+		contents := make([]string, 1, len(emails)+1)
+		contents[0] = title + ":"
+		for i, email := range emails {
+			if i > 10 {
+				contents = append(contents, "......")
+				break
+			}
+			contents = append(contents, fmt.Sprintf("%d. [%s] %s(%s)", i+1,
+				email.Mailbox(), email.Subject, email.Sender()))
+		}
+		msg["text"] = map[string]interface{}{
+			"content":               strings.Join(contents, "\n"),
+			"mentioned_list":        config.MentionedList,
+			"mentioned_mobile_list": config.MentionedMobileList,
+		}
+	}
+
+	body := bytes.NewBuffer(make([]byte, 0, 512))
+	if err = json.NewEncoder(body).Encode(msg); err != nil {
+		return
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, urlprefix+url.QueryEscape(config.Key), body)
+	if err != nil {
+		return
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		ErrCode int    `json:"errcode"`
+		ErrMsg  string `json:"errmsg"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return
+	} else if result.ErrCode != 0 {
+		err = fmt.Errorf("errcode=%d, errmsg=%s", result.ErrCode, result.ErrMsg)
+		if !retryableErrCodes[result.ErrCode] {
+			err = notice.PermanentError(err)
+		}
+	}
+
+	return
+}
+
+// retryableErrCodes are the transient errcodes, that's, the system is busy
+// and the api frequency is out of limit. The others, such as the invalid
+// webhook key or message, are permanent.
+var retryableErrCodes = map[int]bool{-1: true, 45009: true}